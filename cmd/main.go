@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/zinrai/ansible-template-render/internal/generator"
 	"github.com/zinrai/ansible-template-render/internal/logger"
+	"github.com/zinrai/ansible-template-render/internal/processor"
 )
 
 var (
@@ -16,9 +18,15 @@ var (
 	date    = "unknown"
 )
 
+// Exit status when the rendered files differ from the -compare reference,
+// between revisions or from the expected output of a scenario
+const exitDifferences = 3
+
 const usage = `Usage:
-  ansible-template-render run      [-i INV] PLAYBOOK [-- ANSIBLE_ARGS...]
-  ansible-template-render generate [-i INV] PLAYBOOK [-- ANSIBLE_ARGS...]
+  ansible-template-render run      -i INV [OPTIONS] PLAYBOOK [-- ANSIBLE_ARGS...]
+  ansible-template-render generate -i INV [OPTIONS] PLAYBOOK [-- ANSIBLE_ARGS...]
+  ansible-template-render diff-revisions -i INV [OPTIONS] BASE HEAD PLAYBOOK [-- ANSIBLE_ARGS...]
+  ansible-template-render test [OPTIONS] SCENARIOS_DIR [-- ANSIBLE_ARGS...]
   ansible-template-render version
 `
 
@@ -33,6 +41,10 @@ func main() {
 		runSubcommand(os.Args[2:], false)
 	case "generate":
 		runSubcommand(os.Args[2:], true)
+	case "diff-revisions":
+		diffRevisionsSubcommand(os.Args[2:])
+	case "test":
+		testSubcommand(os.Args[2:])
 	case "version":
 		fmt.Printf("ansible-template-render %s (commit %s, built %s)\n", version, commit, date)
 	case "-h", "--help", "help":
@@ -53,9 +65,12 @@ func runSubcommand(args []string, generateOnly bool) {
 	beforeDash, afterDash := splitAtDoubleDash(args)
 
 	fs := flag.NewFlagSet(name, flag.ExitOnError)
-	inventory := fs.String("i", "", "Path to the inventory file (required)")
+	renderOptions := addRenderFlags(fs)
+	outputDir := fs.String("o", "", "Directory receiving the rendered files (default output, or a directory in the workspace with -compare)")
+	fs.StringVar(outputDir, "output", "", "Alias for -o")
+	compareDir := fs.String("compare", "", "Reference directory to diff the rendered files against; differences exit with status 3")
 	fs.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: ansible-template-render %s [-i INV] PLAYBOOK [-- ANSIBLE_ARGS...]\n", name)
+		fmt.Fprintf(os.Stderr, "Usage: ansible-template-render %s -i INV [OPTIONS] PLAYBOOK [-- ANSIBLE_ARGS...]\n", name)
 		fs.PrintDefaults()
 	}
 	if err := fs.Parse(beforeDash); err != nil {
@@ -67,19 +82,17 @@ func runSubcommand(args []string, generateOnly bool) {
 		fs.Usage()
 		os.Exit(2)
 	}
-	playbook := positional[0]
 
-	if *inventory == "" {
-		logger.Error("-i is required")
-		fs.Usage()
-		os.Exit(2)
-	}
+	opts := renderOptions()
+	requireInventory(fs, opts)
+	opts.PlaybookPath = positional[0]
+	opts.AnsibleArgs = afterDash
+	opts.OutputDir = *outputDir
+	opts.CompareDir = *compareDir
+	opts.GenerateOnly = generateOnly
 
-	ansibleArgs := strings.Join(afterDash, " ")
-
-	if err := generator.RunTemplateGeneration(playbook, *inventory, ansibleArgs, generateOnly); err != nil {
-		logger.Error("Error occurred", "error", err)
-		os.Exit(1)
+	if err := generator.RunTemplateGeneration(opts); err != nil {
+		exitOnError(err)
 	}
 
 	if generateOnly {
@@ -89,6 +102,141 @@ func runSubcommand(args []string, generateOnly bool) {
 	}
 }
 
+func diffRevisionsSubcommand(args []string) {
+	beforeDash, afterDash := splitAtDoubleDash(args)
+
+	fs := flag.NewFlagSet("diff-revisions", flag.ExitOnError)
+	renderOptions := addRenderFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ansible-template-render diff-revisions -i INV [OPTIONS] BASE HEAD PLAYBOOK [-- ANSIBLE_ARGS...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(beforeDash); err != nil {
+		os.Exit(2)
+	}
+
+	positional := fs.Args()
+	if len(positional) != 3 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	opts := generator.RevisionOptions{
+		Options:      renderOptions(),
+		BaseRevision: positional[0],
+		HeadRevision: positional[1],
+	}
+	requireInventory(fs, opts.Options)
+	opts.PlaybookPath = positional[2]
+	opts.AnsibleArgs = afterDash
+
+	if err := generator.CompareRevisions(opts); err != nil {
+		exitOnError(err)
+	}
+}
+
+func testSubcommand(args []string) {
+	beforeDash, afterDash := splitAtDoubleDash(args)
+
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	renderOptions := addRenderFlags(fs)
+	update := fs.Bool("update", false, "Rewrite the expected output of every scenario with the rendered files")
+	junitPath := fs.String("junit", "", "Write a JUnit XML report to this file")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ansible-template-render test [OPTIONS] SCENARIOS_DIR [-- ANSIBLE_ARGS...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(beforeDash); err != nil {
+		os.Exit(2)
+	}
+
+	positional := fs.Args()
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	opts := generator.ScenarioOptions{
+		Options:      renderOptions(),
+		ScenariosDir: positional[0],
+		Update:       *update,
+		JUnitPath:    *junitPath,
+	}
+	opts.AnsibleArgs = afterDash
+
+	if err := generator.RunScenarios(opts); err != nil {
+		exitOnError(err)
+	}
+}
+
+// Registers the flags shared by the rendering subcommands; the returned
+// function builds the options from them once parsed
+func addRenderFlags(fs *flag.FlagSet) func() generator.Options {
+	var inventories stringList
+	fs.Var(&inventories, "i", "Inventory file or directory (repeatable; required, except for test scenarios naming their own)")
+	inventoryMode := fs.String("inventory-mode", processor.InventoryFlatten,
+		"How hosts are made local: "+processor.InventoryFlatten+" copies the inventory with ansible_connection set on every host, "+
+			processor.InventoryKeep+" uses the original inventory sources with -e "+processor.LocalConnectionVar)
+	limit := fs.String("limit", "", "Ansible host pattern selecting the hosts to render for, e.g. 'webservers:&eu-west:!canary'")
+	fs.StringVar(limit, "l", "", "Alias for -limit")
+	baselineDir := fs.String("baseline", "", "Directory with baseline files for lineinfile, blockinfile and ini_file edits")
+	perHost := fs.Bool("per-host", false, "Render templates for every host into <output>/<inventory_hostname>/")
+	copySources := fs.Bool("copy-src", false, "Also render copy tasks with a src file, not only inline content")
+	configPath := fs.String("config", "", "Configuration file (default "+generator.DefaultConfigFile+" if present)")
+	var moduleSpecs stringList
+	fs.Var(&moduleSpecs, "template-module", "Additional template-like module, NAME or NAME=DEST_PARAM[,DEST_PARAM...] (repeatable)")
+	var validators stringList
+	fs.Var(&validators, "validator", "Local command for a validate command's executable, NAME=COMMAND with %s for the file (repeatable)")
+	noValidate := fs.Bool("no-validate", false, "Do not run validate commands against the rendered files")
+	keepWorkspace := fs.Bool("keep-workspace", false, "Keep the temporary workspace for debugging")
+
+	return func() generator.Options {
+		return generator.Options{
+			InventoryPaths: inventories,
+			InventoryMode:  *inventoryMode,
+			Limit:          *limit,
+			BaselineDir:    *baselineDir,
+			PerHost:        *perHost,
+			CopySources:    *copySources,
+			ConfigPath:     *configPath,
+			ModuleSpecs:    moduleSpecs,
+			Validators:     validators,
+			NoValidate:     *noValidate,
+			KeepWorkspace:  *keepWorkspace,
+		}
+	}
+}
+
+// Exits with a usage error when no inventory is given
+func requireInventory(fs *flag.FlagSet, opts generator.Options) {
+	if len(opts.InventoryPaths) == 0 {
+		logger.Error("-i is required")
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// Exits with the status for a failed or differing render
+func exitOnError(err error) {
+	if errors.Is(err, generator.ErrDifferences) {
+		os.Exit(exitDifferences)
+	}
+	logger.Error("Error occurred", "error", err)
+	os.Exit(1)
+}
+
+// Collects the values of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func splitAtDoubleDash(args []string) ([]string, []string) {
 	for i, a := range args {
 		if a == "--" {
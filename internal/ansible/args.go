@@ -0,0 +1,95 @@
+package ansible
+
+import "strings"
+
+// Returns the arguments of a module: its parameter mapping, or the
+// key=value pairs of the free-form syntax, e.g. "src=a.j2 dest=/etc/a.conf".
+// Free-form arguments are returned as a new mapping, which replaces the
+// string when the task is modified.
+func moduleArgs(value interface{}) (map[string]interface{}, bool) {
+	switch args := value.(type) {
+	case map[string]interface{}:
+		return args, true
+	case string:
+		return parseKeyValueArgs(args)
+	}
+	return nil, false
+}
+
+// Parses free-form key=value arguments. Values may be quoted, and spaces
+// inside Jinja expressions do not separate arguments. Bare words, which
+// file modules do not accept, make the arguments unsupported.
+func parseKeyValueArgs(args string) (map[string]interface{}, bool) {
+	params := make(map[string]interface{})
+
+	for _, token := range splitArgs(args) {
+		key, value, ok := strings.Cut(token, "=")
+		if !ok || key == "" || strings.ContainsAny(key, "\"'{") {
+			return nil, false
+		}
+		params[key] = unquoteArg(value)
+	}
+
+	if len(params) == 0 {
+		return nil, false
+	}
+	return params, true
+}
+
+// Splits free-form arguments on whitespace outside quotes and Jinja expressions
+func splitArgs(args string) []string {
+	var tokens []string
+	var current strings.Builder
+	var quote byte
+	depth := 0
+
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(args) {
+				current.WriteByte(c)
+				i++
+				c = args[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '{' && i+1 < len(args) && strings.ContainsRune("{%#", rune(args[i+1])):
+			depth++
+			current.WriteByte(c)
+			i++
+			c = args[i]
+		case depth > 0 && strings.ContainsRune("}%#", rune(c)) && i+1 < len(args) && args[i+1] == '}':
+			depth--
+			current.WriteByte(c)
+			i++
+			c = args[i]
+		case depth == 0 && (c == '"' || c == '\''):
+			quote = c
+		case depth == 0 && (c == ' ' || c == '\t' || c == '\n'):
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteByte(c)
+	}
+
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+// Removes the quotes around a free-form argument value
+func unquoteArg(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		inner := value[1 : len(value)-1]
+		if value[0] == '"' {
+			return strings.ReplaceAll(inner, `\"`, `"`)
+		}
+		return strings.ReplaceAll(inner, `\'`, `'`)
+	}
+	return value
+}
@@ -0,0 +1,66 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyValueArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     string
+		expected map[string]interface{}
+		ok       bool
+	}{
+		{
+			name:     "plain values",
+			args:     "src=a.j2 dest=/etc/a.conf",
+			expected: map[string]interface{}{"src": "a.j2", "dest": "/etc/a.conf"},
+			ok:       true,
+		},
+		{
+			name:     "quoted values and Jinja expressions",
+			args:     `src="my file.j2"  dest={{ conf_dir }}/{{ item | default('a b') }}.conf mode='0644'`,
+			expected: map[string]interface{}{"src": "my file.j2", "dest": "{{ conf_dir }}/{{ item | default('a b') }}.conf", "mode": "0644"},
+			ok:       true,
+		},
+		{
+			name: "bare word",
+			args: "a.j2 dest=/etc/a.conf",
+			ok:   false,
+		},
+		{
+			name: "empty",
+			args: "  ",
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := parseKeyValueArgs(tt.args)
+			if ok != tt.ok {
+				t.Fatalf("parseKeyValueArgs() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("parseKeyValueArgs() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestModuleArgs(t *testing.T) {
+	params := map[string]interface{}{"src": "a.j2"}
+	if result, ok := moduleArgs(params); !ok || !reflect.DeepEqual(result, params) {
+		t.Errorf("moduleArgs(mapping) = %v, %v, want the mapping itself", result, ok)
+	}
+
+	expected := map[string]interface{}{"name": "nginx", "tasks_from": "vhost"}
+	if result, ok := moduleArgs("name=nginx tasks_from=vhost"); !ok || !reflect.DeepEqual(result, expected) {
+		t.Errorf("moduleArgs(free-form) = %v, %v, want %v", result, ok, expected)
+	}
+
+	if _, ok := moduleArgs(nil); ok {
+		t.Errorf("moduleArgs(nil) should not return arguments")
+	}
+}
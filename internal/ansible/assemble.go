@@ -0,0 +1,116 @@
+package ansible
+
+import (
+	"path/filepath"
+)
+
+// Module keys of the assemble module
+var assembleModuleKeys = []string{"assemble", "ansible.builtin.assemble"}
+
+// Represents a task using the assemble module
+type AssembleTask struct {
+	Task       map[string]interface{}
+	ModuleKey  string
+	ModuleData map[string]interface{}
+}
+
+// Creates a new AssembleTask from a map
+func NewAssembleTask(task map[string]interface{}) (*AssembleTask, bool) {
+	for _, key := range assembleModuleKeys {
+		module, ok := task[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		_, hasSrc := module["src"].(string)
+		_, hasDest := module["dest"].(string)
+		if !hasSrc || !hasDest {
+			return nil, false
+		}
+
+		return &AssembleTask{
+			Task:       task,
+			ModuleKey:  key,
+			ModuleData: module,
+		}, true
+	}
+
+	return nil, false
+}
+
+// Determines if a task assembles a directory of rendered fragments
+func IsRenderedAssembleTask(task map[string]interface{}, opts RenderOptions) bool {
+	assembleTask, ok := NewAssembleTask(task)
+	if !ok {
+		return false
+	}
+	return opts.IsRenderedDir(assembleTask.GetSrcPath())
+}
+
+// Returns the fragment directory of the assemble task
+func (a *AssembleTask) GetSrcPath() string {
+	srcPath, _ := a.ModuleData["src"].(string)
+	return srcPath
+}
+
+// Returns the destination path of the assembled file
+func (a *AssembleTask) GetDestPath() string {
+	destPath, _ := a.ModuleData["dest"].(string)
+	return destPath
+}
+
+// Modifies the assemble task to join the rendered fragments into the output directory
+func (a *AssembleTask) Modify(opts RenderOptions) {
+	a.ModuleData["src"] = opts.OutputPath(a.GetSrcPath())
+	a.ModuleData["dest"] = opts.OutputPath(a.GetDestPath())
+	a.Task[a.ModuleKey] = a.ModuleData
+
+	// The rendered fragments are on the controller, which runs the task
+	delete(a.ModuleData, "remote_src")
+
+	renderLocally(a.Task, opts)
+	removeFileAttributes(a.ModuleData)
+	delete(a.ModuleData, "backup")
+
+	// Validation runs locally after rendering, see GetValidateCommand
+	delete(a.ModuleData, "validate")
+}
+
+// Returns the validate command of the assembled file, with %s standing for the file
+func (a *AssembleTask) GetValidateCommand() string {
+	return validateCommand(a.ModuleData)
+}
+
+// Modifies an assemble task to operate on the output directory
+func ModifyAssembleTask(task map[string]interface{}, opts RenderOptions) {
+	assembleTask, ok := NewAssembleTask(task)
+	if !ok {
+		return
+	}
+
+	assembleTask.Modify(opts)
+}
+
+// Extracts the directories that template tasks render into,
+// descending into block, rescue and always sections
+func ExtractRenderedDirs(tasks []map[string]interface{}, opts RenderOptions) []string {
+	var dirs []string
+
+	for _, task := range tasks {
+		if templateTask, ok := NewTemplateTask(task, opts); ok && templateTask.GetDestPath() != "" {
+			dirs = append(dirs, filepath.Dir(filepath.Clean(templateTask.GetDestPath())))
+		}
+
+		for _, section := range BlockSections {
+			tasksList, ok := task[section].([]interface{})
+			if !ok {
+				continue
+			}
+
+			sectionTasks, _ := convertTasksList(tasksList)
+			dirs = append(dirs, ExtractRenderedDirs(sectionTasks, opts)...)
+		}
+	}
+
+	return dirs
+}
@@ -0,0 +1,122 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsRenderedAssembleTask(t *testing.T) {
+	opts := RenderOptions{RenderedDirs: map[string]bool{"/etc/haproxy/conf.d": true}}
+
+	tests := []struct {
+		name     string
+		task     map[string]interface{}
+		expected bool
+	}{
+		{
+			name: "assembles rendered fragments",
+			task: map[string]interface{}{
+				"ansible.builtin.assemble": map[string]interface{}{
+					"src":  "/etc/haproxy/conf.d/",
+					"dest": "/etc/haproxy/haproxy.cfg",
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "assembles other fragments",
+			task: map[string]interface{}{
+				"assemble": map[string]interface{}{
+					"src":  "/etc/sudoers.d",
+					"dest": "/etc/sudoers",
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "missing dest",
+			task: map[string]interface{}{
+				"assemble": map[string]interface{}{
+					"src": "/etc/haproxy/conf.d",
+				},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := IsRenderedAssembleTask(tt.task, opts)
+			if result != tt.expected {
+				t.Errorf("IsRenderedAssembleTask() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestModifyAssembleTask(t *testing.T) {
+	task := map[string]interface{}{
+		"assemble": map[string]interface{}{
+			"src":        "/etc/haproxy/conf.d",
+			"dest":       "/etc/haproxy/haproxy.cfg",
+			"remote_src": true,
+			"mode":       "0644",
+			"validate":   "haproxy -c -f %s",
+		},
+		"notify": "reload haproxy",
+	}
+
+	assembleTask, _ := NewAssembleTask(task)
+	if got := assembleTask.GetValidateCommand(); got != "haproxy -c -f %s" {
+		t.Errorf("GetValidateCommand() = %q, want %q", got, "haproxy -c -f %s")
+	}
+
+	ModifyAssembleTask(task, RenderOptions{})
+
+	module := task["assemble"].(map[string]interface{})
+	if module["src"] != "output/etc/haproxy/conf.d" {
+		t.Errorf("src not modified correctly: got %v", module["src"])
+	}
+	if module["dest"] != "output/etc/haproxy/haproxy.cfg" {
+		t.Errorf("dest not modified correctly: got %v", module["dest"])
+	}
+	for _, key := range []string{"remote_src", "mode", "validate"} {
+		if _, ok := module[key]; ok {
+			t.Errorf("%s was not removed", key)
+		}
+	}
+	if !reflect.DeepEqual(task["tags"], []interface{}{"render_config"}) {
+		t.Errorf("tags not set correctly: %v", task["tags"])
+	}
+}
+
+func TestExtractRenderedDirs(t *testing.T) {
+	tasks := []map[string]interface{}{
+		{
+			"template": map[string]interface{}{
+				"src":  "frontend.cfg.j2",
+				"dest": "/etc/haproxy/conf.d/10-frontend.cfg",
+			},
+		},
+		{
+			"block": []interface{}{
+				map[string]interface{}{
+					"ansible.builtin.template": map[string]interface{}{
+						"src":  "admins.j2",
+						"dest": "/etc/sudoers.d/admins",
+					},
+				},
+			},
+		},
+		{
+			"command": "true",
+		},
+	}
+
+	expected := []string{"/etc/haproxy/conf.d", "/etc/sudoers.d"}
+	result := ExtractRenderedDirs(tasks, RenderOptions{})
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ExtractRenderedDirs() = %v, want %v", result, expected)
+	}
+}
@@ -0,0 +1,90 @@
+package ansible
+
+// Modules that edit an existing file in place, with the keys naming the
+// edited file (the primary option first, then its aliases)
+var editModulePathKeys = map[string][]string{
+	"lineinfile":                  {"path", "dest", "destfile", "name"},
+	"ansible.builtin.lineinfile":  {"path", "dest", "destfile", "name"},
+	"blockinfile":                 {"path", "dest", "destfile", "name"},
+	"ansible.builtin.blockinfile": {"path", "dest", "destfile", "name"},
+	"ini_file":                    {"path", "dest"},
+	"community.general.ini_file":  {"path", "dest"},
+}
+
+// Represents a task editing a file in place (lineinfile, blockinfile, ini_file)
+type EditTask struct {
+	Task       map[string]interface{}
+	ModuleKey  string
+	ModuleData map[string]interface{}
+	PathKey    string // Key holding the edited file's path
+}
+
+// Creates a new EditTask from a map
+func NewEditTask(task map[string]interface{}) (*EditTask, bool) {
+	for moduleKey, pathKeys := range editModulePathKeys {
+		moduleData, ok := task[moduleKey].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, pathKey := range pathKeys {
+			if _, ok := moduleData[pathKey].(string); ok {
+				return &EditTask{
+					Task:       task,
+					ModuleKey:  moduleKey,
+					ModuleData: moduleData,
+					PathKey:    pathKey,
+				}, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Determines if a task edits a file in place
+func IsEditTask(task map[string]interface{}) bool {
+	_, ok := NewEditTask(task)
+	return ok
+}
+
+// Returns the path of the edited file
+func (e *EditTask) GetDestPath() string {
+	destPath, _ := e.ModuleData[e.PathKey].(string)
+	return destPath
+}
+
+// Modifies the edit task to operate on the copy in the output directory
+func (e *EditTask) Modify(opts RenderOptions) {
+	destPath := e.GetDestPath()
+	if destPath == "" {
+		return
+	}
+
+	e.ModuleData[e.PathKey] = opts.OutputPath(destPath)
+	e.Task[e.ModuleKey] = e.ModuleData
+
+	renderLocally(e.Task, opts)
+	removeFileAttributes(e.ModuleData)
+
+	// Backups would land next to the rendered file
+	delete(e.ModuleData, "backup")
+
+	// Validation runs locally after rendering, see GetValidateCommand
+	delete(e.ModuleData, "validate")
+}
+
+// Returns the validate command of the edit, with %s standing for the file
+func (e *EditTask) GetValidateCommand() string {
+	return validateCommand(e.ModuleData)
+}
+
+// Modifies an edit task to operate on the output directory
+func ModifyEditTask(task map[string]interface{}, opts RenderOptions) {
+	editTask, isEdit := NewEditTask(task)
+	if !isEdit {
+		return
+	}
+
+	editTask.Modify(opts)
+}
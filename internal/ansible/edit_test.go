@@ -0,0 +1,133 @@
+package ansible
+
+import (
+	"testing"
+)
+
+func TestNewEditTask(t *testing.T) {
+	tests := []struct {
+		name            string
+		task            map[string]interface{}
+		expectedOk      bool
+		expectedPathKey string
+		expectedPath    string
+	}{
+		{
+			name: "lineinfile with path",
+			task: map[string]interface{}{
+				"ansible.builtin.lineinfile": map[string]interface{}{
+					"path": "/etc/ssh/sshd_config",
+					"line": "PermitRootLogin no",
+				},
+			},
+			expectedOk:      true,
+			expectedPathKey: "path",
+			expectedPath:    "/etc/ssh/sshd_config",
+		},
+		{
+			name: "blockinfile with dest alias",
+			task: map[string]interface{}{
+				"blockinfile": map[string]interface{}{
+					"dest":  "/etc/hosts",
+					"block": "10.0.0.1 db",
+				},
+			},
+			expectedOk:      true,
+			expectedPathKey: "dest",
+			expectedPath:    "/etc/hosts",
+		},
+		{
+			name: "ini_file",
+			task: map[string]interface{}{
+				"community.general.ini_file": map[string]interface{}{
+					"path":    "/etc/app.ini",
+					"section": "main",
+					"option":  "debug",
+					"value":   "false",
+				},
+			},
+			expectedOk:      true,
+			expectedPathKey: "path",
+			expectedPath:    "/etc/app.ini",
+		},
+		{
+			name: "free-form arguments",
+			task: map[string]interface{}{
+				"lineinfile": "path=/etc/hosts line=foo",
+			},
+			expectedOk: false,
+		},
+		{
+			name: "not an edit task",
+			task: map[string]interface{}{
+				"template": map[string]interface{}{
+					"src":  "a.j2",
+					"dest": "/etc/a",
+				},
+			},
+			expectedOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			editTask, ok := NewEditTask(tt.task)
+			if ok != tt.expectedOk {
+				t.Fatalf("NewEditTask() ok = %v, want %v", ok, tt.expectedOk)
+			}
+			if !ok {
+				return
+			}
+
+			if editTask.PathKey != tt.expectedPathKey {
+				t.Errorf("PathKey = %v, want %v", editTask.PathKey, tt.expectedPathKey)
+			}
+			if editTask.GetDestPath() != tt.expectedPath {
+				t.Errorf("GetDestPath() = %v, want %v", editTask.GetDestPath(), tt.expectedPath)
+			}
+		})
+	}
+}
+
+func TestEditTask_Modify(t *testing.T) {
+	task := map[string]interface{}{
+		"lineinfile": map[string]interface{}{
+			"path":     "/etc/ssh/sshd_config",
+			"regexp":   "^PermitRootLogin",
+			"line":     "PermitRootLogin no",
+			"backup":   true,
+			"owner":    "root",
+			"validate": "sshd -t -f %s",
+		},
+		"notify": "restart sshd",
+	}
+
+	editTask, _ := NewEditTask(task)
+	if got := editTask.GetValidateCommand(); got != "sshd -t -f %s" {
+		t.Errorf("GetValidateCommand() = %q, want %q", got, "sshd -t -f %s")
+	}
+
+	ModifyEditTask(task, RenderOptions{OutputDir: "/srv/out", PerHost: true})
+
+	module := task["lineinfile"].(map[string]interface{})
+	expectedPath := "/srv/out/{{ inventory_hostname }}/etc/ssh/sshd_config"
+	if module["path"] != expectedPath {
+		t.Errorf("path not modified correctly: got %v, want %v", module["path"], expectedPath)
+	}
+
+	for _, key := range []string{"backup", "owner", "validate"} {
+		if _, ok := module[key]; ok {
+			t.Errorf("%s was not removed", key)
+		}
+	}
+
+	if task["delegate_to"] != "localhost" {
+		t.Errorf("delegate_to not set correctly: %v", task["delegate_to"])
+	}
+	if _, hasRunOnce := task["run_once"]; hasRunOnce {
+		t.Errorf("run_once should not be set in per-host mode")
+	}
+	if _, hasNotify := task["notify"]; hasNotify {
+		t.Errorf("notify was not removed")
+	}
+}
@@ -0,0 +1,62 @@
+package ansible
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Describes an additional module that renders a file like the template module
+type TemplateModule struct {
+	Name     string   `yaml:"name"`      // Module name as written in tasks, e.g. acme.platform.render_config
+	DestKeys []string `yaml:"dest_keys"` // Parameters holding the destination path, "dest" when empty
+}
+
+// Returns the parameters holding the destination path
+func (m TemplateModule) destKeys() []string {
+	if len(m.DestKeys) == 0 {
+		return []string{"dest"}
+	}
+	return m.DestKeys
+}
+
+// Parses a module declaration of the form NAME or NAME=PARAM[,PARAM...]
+func ParseTemplateModule(spec string) (TemplateModule, error) {
+	name, params, hasParams := strings.Cut(strings.TrimSpace(spec), "=")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return TemplateModule{}, fmt.Errorf("template module %q has no name", spec)
+	}
+
+	module := TemplateModule{Name: name}
+	if !hasParams {
+		return module, nil
+	}
+
+	for _, param := range strings.Split(params, ",") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			return TemplateModule{}, fmt.Errorf("template module %q has an empty destination parameter", spec)
+		}
+		module.DestKeys = append(module.DestKeys, param)
+	}
+
+	return module, nil
+}
+
+// Identifies an additional template module in a task, with the key of its destination
+func findExtraTemplateModule(task map[string]interface{}, modules []TemplateModule) (string, map[string]interface{}, string) {
+	for _, templateModule := range modules {
+		module, ok := task[templateModule.Name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, destKey := range templateModule.destKeys() {
+			if _, ok := module[destKey].(string); ok {
+				return templateModule.Name, module, destKey
+			}
+		}
+	}
+
+	return "", nil, ""
+}
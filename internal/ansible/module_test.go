@@ -0,0 +1,91 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTemplateModule(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      string
+		expected  TemplateModule
+		expectErr bool
+	}{
+		{
+			name:     "name only",
+			spec:     "acme.platform.render_config",
+			expected: TemplateModule{Name: "acme.platform.render_config"},
+		},
+		{
+			name:     "destination parameters",
+			spec:     "community.general.xml=path, dest",
+			expected: TemplateModule{Name: "community.general.xml", DestKeys: []string{"path", "dest"}},
+		},
+		{
+			name:      "missing name",
+			spec:      "=dest",
+			expectErr: true,
+		},
+		{
+			name:      "empty parameter",
+			spec:      "acme.render=dest,",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseTemplateModule(tt.spec)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("ParseTemplateModule() expected error, got %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTemplateModule() error = %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("ParseTemplateModule() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTemplateTask_ExtraModules(t *testing.T) {
+	opts := RenderOptions{TemplateModules: []TemplateModule{
+		{Name: "acme.platform.render_config"},
+		{Name: "community.general.xml", DestKeys: []string{"path"}},
+	}}
+
+	task := map[string]interface{}{
+		"community.general.xml": map[string]interface{}{
+			"path":  "/etc/app/app.xml",
+			"xpath": "/config/port",
+			"value": "8080",
+		},
+	}
+
+	if !IsTemplateTask(task, opts) {
+		t.Fatalf("IsTemplateTask() = false for configured module")
+	}
+
+	ModifyTemplateTask(task, opts)
+
+	module := task["community.general.xml"].(map[string]interface{})
+	if module["path"] != "output/etc/app/app.xml" {
+		t.Errorf("path not modified correctly: got %v", module["path"])
+	}
+	if task["delegate_to"] != "localhost" {
+		t.Errorf("delegate_to not set correctly: %v", task["delegate_to"])
+	}
+
+	// Unconfigured modules are left alone
+	other := map[string]interface{}{
+		"acme.platform.other": map[string]interface{}{"dest": "/etc/other"},
+	}
+	if IsTemplateTask(other, opts) {
+		t.Errorf("IsTemplateTask() = true for unconfigured module")
+	}
+}
@@ -3,10 +3,21 @@ package ansible
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zinrai/ansible-template-render/internal/finder"
+	"github.com/zinrai/ansible-template-render/internal/logger"
 
 	"github.com/goccy/go-yaml"
 )
 
+// Play sections that hold task lists
+var PlayTaskSections = []string{"pre_tasks", "tasks", "post_tasks", "handlers"}
+
+// Play-level keys that import another playbook
+var playbookImportKeys = []string{"import_playbook", "ansible.builtin.import_playbook"}
+
 // Represents a role in a playbook
 type PlaybookRole struct {
 	Name string
@@ -39,13 +50,15 @@ func LoadPlaybook(path string) ([]map[string]interface{}, error) {
 }
 
 // Extracts roles used in a playbook
-func ExtractRolesFromPlaybook(playbook []map[string]interface{}) []string {
-	extractor := PlaybookRoleExtractor{}
+func ExtractRolesFromPlaybook(playbook []map[string]interface{}, paths finder.SearchPaths) []string {
+	extractor := PlaybookRoleExtractor{Paths: paths}
 	return extractor.Extract(playbook)
 }
 
 // Extracts roles from a playbook
-type PlaybookRoleExtractor struct{}
+type PlaybookRoleExtractor struct {
+	Paths finder.SearchPaths // Where collections qualifying short role names are looked up
+}
 
 // Extracts roles from a playbook
 func (e *PlaybookRoleExtractor) Extract(playbook []map[string]interface{}) []string {
@@ -58,11 +71,13 @@ func (e *PlaybookRoleExtractor) Extract(playbook []map[string]interface{}) []str
 			continue
 		}
 
+		collections := e.extractCollections(play)
 		for _, role := range rolesList {
 			roleName := e.extractRoleName(role)
 			if roleName == "" {
 				continue
 			}
+			roleName = e.Paths.QualifyRoleName(roleName, collections)
 
 			if roleMap[roleName] {
 				continue
@@ -73,9 +88,123 @@ func (e *PlaybookRoleExtractor) Extract(playbook []map[string]interface{}) []str
 		}
 	}
 
+	// Roles included or imported from play tasks
+	for _, play := range playbook {
+		collections := e.extractCollections(play)
+		for _, include := range e.extractRoleIncludes(play) {
+			include.Name = e.Paths.QualifyRoleName(include.Name, collections)
+
+			if roleMap[include.Name] {
+				continue
+			}
+
+			roleMap[include.Name] = true
+			roles = append(roles, include.Name)
+		}
+	}
+
 	return roles
 }
 
+// Checks the tasks_from, vars_from and defaults_from files of the roles
+// included or imported from play tasks
+func CheckPlaybookRoleIncludes(playbook []map[string]interface{}, paths finder.SearchPaths) error {
+	extractor := PlaybookRoleExtractor{Paths: paths}
+
+	for _, play := range playbook {
+		collections := extractor.extractCollections(play)
+		for _, include := range extractor.extractRoleIncludes(play) {
+			include.Name = paths.QualifyRoleName(include.Name, collections)
+			if err := CheckRoleIncludeFiles(include, paths); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Extracts the play-level collections keyword
+func (e *PlaybookRoleExtractor) extractCollections(play map[string]interface{}) []string {
+	collectionsList, ok := play["collections"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var collections []string
+	for _, collection := range collectionsList {
+		if name, ok := collection.(string); ok && name != "" {
+			collections = append(collections, name)
+		}
+	}
+
+	return collections
+}
+
+// Extracts include_role and import_role references from every task section of a play
+func (e *PlaybookRoleExtractor) extractRoleIncludes(play map[string]interface{}) []RoleInclude {
+	var includes []RoleInclude
+
+	for _, section := range PlayTaskSections {
+		tasksList, ok := play[section].([]interface{})
+		if !ok {
+			continue
+		}
+
+		tasks, _ := convertTasksList(tasksList)
+		includes = append(includes, ExtractRoleIncludes(tasks)...)
+	}
+
+	return includes
+}
+
+// Extracts the task files included or imported by every task section of the plays
+func ExtractPlayTaskIncludes(playbook []map[string]interface{}) []string {
+	var includes []string
+
+	for _, play := range playbook {
+		for _, section := range PlayTaskSections {
+			tasksList, ok := play[section].([]interface{})
+			if !ok {
+				continue
+			}
+
+			tasks, _ := convertTasksList(tasksList)
+			includes = append(includes, ExtractTaskIncludes(tasks)...)
+		}
+	}
+
+	return includes
+}
+
+// Extracts the vars_files of the plays. An entry listing alternatives,
+// of which Ansible loads the first one found, contributes all of them.
+func ExtractPlayVarsFiles(playbook []map[string]interface{}) []string {
+	var varsFiles []string
+
+	for _, play := range playbook {
+		switch value := play["vars_files"].(type) {
+		case string:
+			varsFiles = append(varsFiles, strings.TrimSpace(value))
+		case []interface{}:
+			for _, entry := range value {
+				switch entryValue := entry.(type) {
+				case string:
+					varsFiles = append(varsFiles, strings.TrimSpace(entryValue))
+				case []interface{}:
+					for _, alternative := range entryValue {
+						if path, ok := alternative.(string); ok {
+							varsFiles = append(varsFiles, strings.TrimSpace(path))
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return varsFiles
+}
+
 // Role name from different role specifications
 func (e *PlaybookRoleExtractor) extractRoleName(role interface{}) string {
 	// Direct string role name
@@ -101,3 +230,114 @@ func (e *PlaybookRoleExtractor) extractRoleName(role interface{}) string {
 
 	return ""
 }
+
+// Extracts the playbook paths imported by a playbook, in order
+func ExtractImportedPlaybooks(playbook []map[string]interface{}) []string {
+	var imports []string
+
+	for _, play := range playbook {
+		for _, key := range playbookImportKeys {
+			importPath, ok := play[key].(string)
+			if !ok || importPath == "" {
+				continue
+			}
+			imports = append(imports, strings.TrimSpace(importPath))
+		}
+	}
+
+	return imports
+}
+
+// Resolves a playbook and every playbook it imports
+type PlaybookImportResolver struct {
+	visited  map[string]bool // Playbooks already collected
+	visiting map[string]bool // Playbooks on the current import chain
+	order    []string
+}
+
+// Returns the entry playbook followed by all playbooks it imports, recursively
+func (r *PlaybookImportResolver) Resolve(entryPath string) ([]string, error) {
+	r.visited = make(map[string]bool)
+	r.visiting = make(map[string]bool)
+	r.order = nil
+
+	absPath, err := filepath.Abs(entryPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolving playbook path: %w", err)
+	}
+
+	if err := r.visit(absPath); err != nil {
+		return nil, err
+	}
+
+	return r.order, nil
+}
+
+// Collects a playbook and then follows its imports
+func (r *PlaybookImportResolver) visit(playbookPath string) error {
+	if r.visiting[playbookPath] {
+		return fmt.Errorf("playbook import cycle detected: %s", playbookPath)
+	}
+
+	// Already collected through another import chain
+	if r.visited[playbookPath] {
+		return nil
+	}
+
+	playbook, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		return fmt.Errorf("loading playbook %s: %w", playbookPath, err)
+	}
+
+	r.visiting[playbookPath] = true
+	defer delete(r.visiting, playbookPath)
+
+	r.visited[playbookPath] = true
+	r.order = append(r.order, playbookPath)
+
+	// Imports are relative to the importing playbook
+	baseDir := filepath.Dir(playbookPath)
+	for _, importPath := range ExtractImportedPlaybooks(playbook) {
+		if strings.Contains(importPath, "{{") {
+			logger.Warn("Skipping templated playbook import", "playbook", playbookPath, "import", importPath)
+			continue
+		}
+
+		if !filepath.IsAbs(importPath) {
+			importPath = filepath.Join(baseDir, importPath)
+		}
+
+		if err := r.visit(filepath.Clean(importPath)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the entry playbook followed by all playbooks it imports
+func ResolvePlaybookImports(entryPath string) ([]string, error) {
+	resolver := PlaybookImportResolver{}
+	return resolver.Resolve(entryPath)
+}
+
+// Saves a processed playbook, editing the original file's text so that only
+// changed keys and injected tasks differ. Fails, like SaveTaskFileFrom, when
+// the original layout cannot be edited in place.
+func SavePlaybookFrom(sourcePath string, edit TaskListEdit, path string) error {
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("reading playbook file: %w", err)
+	}
+
+	rewritten, err := RewriteTaskFile(data, edit)
+	if err != nil {
+		return fmt.Errorf("rewriting playbook %s: %w", sourcePath, err)
+	}
+
+	if err := os.WriteFile(path, rewritten, 0644); err != nil {
+		return fmt.Errorf("writing playbook file: %w", err)
+	}
+
+	return nil
+}
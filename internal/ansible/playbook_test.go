@@ -1,8 +1,13 @@
 package ansible
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/zinrai/ansible-template-render/internal/finder"
 )
 
 func TestPlaybookRoleExtractor_Extract(t *testing.T) {
@@ -67,6 +72,40 @@ func TestPlaybookRoleExtractor_Extract(t *testing.T) {
 			},
 			expected: []string{"role1", "role2"},
 		},
+		{
+			name: "roles included from play tasks",
+			playbook: []map[string]interface{}{
+				{
+					"hosts": "all",
+					"roles": []interface{}{"role1"},
+					"pre_tasks": []interface{}{
+						map[string]interface{}{
+							"import_role": map[string]interface{}{
+								"name": "role2",
+							},
+						},
+					},
+					"tasks": []interface{}{
+						map[string]interface{}{
+							"block": []interface{}{
+								map[string]interface{}{
+									"ansible.builtin.include_role": map[string]interface{}{
+										"name":       "role3",
+										"tasks_from": "install",
+									},
+								},
+							},
+						},
+						map[string]interface{}{
+							"include_role": map[string]interface{}{
+								"name": "role1",
+							},
+						},
+					},
+				},
+			},
+			expected: []string{"role1", "role2", "role3"},
+		},
 		{
 			name: "no roles",
 			playbook: []map[string]interface{}{
@@ -176,9 +215,124 @@ func TestExtractRolesFromPlaybook(t *testing.T) {
 	}
 
 	expected := []string{"role1", "role2"}
-	result := ExtractRolesFromPlaybook(playbook)
+	result := ExtractRolesFromPlaybook(playbook, finder.SearchPaths{})
 
 	if !reflect.DeepEqual(result, expected) {
 		t.Errorf("ExtractRolesFromPlaybook() = %v, want %v", result, expected)
 	}
 }
+
+func TestExtractImportedPlaybooks(t *testing.T) {
+	playbook := []map[string]interface{}{
+		{"import_playbook": "webservers.yml"},
+		{"ansible.builtin.import_playbook": "plays/db.yml"},
+		{
+			"hosts": "all",
+			"roles": []interface{}{"role1"},
+		},
+	}
+
+	expected := []string{"webservers.yml", "plays/db.yml"}
+	result := ExtractImportedPlaybooks(playbook)
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ExtractImportedPlaybooks() = %v, want %v", result, expected)
+	}
+}
+
+func TestResolvePlaybookImports(t *testing.T) {
+	helper := NewTestPlaybookHelper(t)
+	defer helper.Cleanup()
+
+	// site.yml imports web.yml and plays/db.yml; both import common.yml
+	helper.CreatePlaybook(t, "site.yml", `---
+- import_playbook: web.yml
+- ansible.builtin.import_playbook: plays/db.yml
+`)
+	helper.CreatePlaybook(t, "web.yml", `---
+- import_playbook: common.yml
+- hosts: web
+  roles:
+    - webserver
+`)
+	helper.CreatePlaybook(t, "plays/db.yml", `---
+- import_playbook: ../common.yml
+- hosts: db
+  roles:
+    - database
+`)
+	helper.CreatePlaybook(t, "common.yml", `---
+- hosts: all
+  roles:
+    - common
+`)
+
+	result, err := ResolvePlaybookImports(filepath.Join(helper.TempDir, "site.yml"))
+	if err != nil {
+		t.Fatalf("ResolvePlaybookImports() error = %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(helper.TempDir, "site.yml"),
+		filepath.Join(helper.TempDir, "web.yml"),
+		filepath.Join(helper.TempDir, "common.yml"),
+		filepath.Join(helper.TempDir, "plays", "db.yml"),
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ResolvePlaybookImports() = %v, want %v", result, expected)
+	}
+}
+
+func TestResolvePlaybookImports_Cycle(t *testing.T) {
+	helper := NewTestPlaybookHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreatePlaybook(t, "a.yml", `---
+- import_playbook: b.yml
+`)
+	helper.CreatePlaybook(t, "b.yml", `---
+- import_playbook: a.yml
+`)
+
+	_, err := ResolvePlaybookImports(filepath.Join(helper.TempDir, "a.yml"))
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("ResolvePlaybookImports() error = %v, want import cycle error", err)
+	}
+}
+
+// Creates a playbook file relative to the temp directory
+func (h *TestPlaybookHelper) CreatePlaybook(t *testing.T, relPath, content string) string {
+	playbookPath := filepath.Join(h.TempDir, relPath)
+	if err := os.MkdirAll(filepath.Dir(playbookPath), 0755); err != nil {
+		t.Fatalf("Failed to create playbook directory: %v", err)
+	}
+	if err := os.WriteFile(playbookPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create playbook: %v", err)
+	}
+	return playbookPath
+}
+
+func TestExtractPlayVarsFiles(t *testing.T) {
+	playbook := []map[string]interface{}{
+		{"import_playbook": "other.yml"},
+		{
+			"hosts":      "all",
+			"vars_files": "vars/common.yml",
+		},
+		{
+			"hosts": "web",
+			"vars_files": []interface{}{
+				"vars/web.yml",
+				[]interface{}{"vars/{{ ansible_os_family }}.yml", "vars/default.yml"},
+			},
+		},
+	}
+
+	expected := []string{"vars/common.yml", "vars/web.yml", "vars/{{ ansible_os_family }}.yml", "vars/default.yml"}
+	result := ExtractPlayVarsFiles(playbook)
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ExtractPlayVarsFiles() = %v, want %v", result, expected)
+	}
+}
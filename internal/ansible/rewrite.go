@@ -0,0 +1,587 @@
+package ansible
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+)
+
+// Describes how a processed task list maps back onto the original list
+type TaskListEdit struct {
+	Tasks   []map[string]interface{}
+	Origins []TaskOrigin // Parallel to Tasks
+}
+
+// Records where a processed task came from
+type TaskOrigin struct {
+	Index    int                     // Index in the original list, -1 for injected tasks
+	Sections map[string]TaskListEdit // Edited task lists of a block or play
+}
+
+// Creates the origin of an injected task
+func InjectedTask() TaskOrigin {
+	return TaskOrigin{Index: -1}
+}
+
+// Rewrites a task file from a processed task list, editing the original text
+// so that everything outside the changed keys and injected tasks is kept
+// byte-for-byte, including comments, key order and quoting
+func RewriteTaskFile(data []byte, edit TaskListEdit) ([]byte, error) {
+	data, seq, err := rootTaskSequence(data)
+	if err != nil {
+		return nil, err
+	}
+
+	editor := newTextEditor(data)
+	if err := editor.editSequence(seq, edit); err != nil {
+		return nil, err
+	}
+
+	return editor.apply(), nil
+}
+
+// Finds the task list of a file: a top-level sequence or a tasks: container.
+// A file holding a single task, which LoadTaskFile reads as a one-task list,
+// is turned into that list first, so tasks can be injected around it.
+// Returns the text the sequence was parsed from.
+func rootTaskSequence(data []byte) ([]byte, *ast.SequenceNode, error) {
+	body, err := parseTaskFileBody(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if seq, ok := body.(*ast.SequenceNode); ok && !seq.IsFlowStyle {
+		return data, seq, nil
+	}
+
+	values, ok := mappingValues(body)
+	if !ok || len(values) == 0 {
+		return nil, nil, fmt.Errorf("unsupported task file layout")
+	}
+
+	for _, kv := range values {
+		if kv.Key.GetToken().Value != "tasks" {
+			continue
+		}
+		if seq, ok := kv.Value.(*ast.SequenceNode); ok {
+			if seq.IsFlowStyle {
+				return nil, nil, fmt.Errorf("unsupported task file layout")
+			}
+			return data, seq, nil
+		}
+	}
+
+	data = singleTaskAsList(data, values[0].Key.GetToken().Position)
+	body, err = parseTaskFileBody(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if seq, ok := body.(*ast.SequenceNode); ok {
+		return data, seq, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported task file layout")
+}
+
+// Parses a task file holding a single YAML document and returns its body
+func parseTaskFileBody(data []byte) (ast.Node, error) {
+	file, err := parser.ParseBytes(data, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("parsing task file: %w", err)
+	}
+
+	if len(file.Docs) != 1 || file.Docs[0].Body == nil {
+		return nil, fmt.Errorf("expected a single YAML document")
+	}
+
+	return file.Docs[0].Body, nil
+}
+
+// Turns the text of a task mapping starting at the given key into a one-task
+// list, indenting the mapping under a dash
+func singleTaskAsList(data []byte, first *token.Position) []byte {
+	lines := strings.Split(string(data), "\n")
+	start := first.Line - 1
+	column := first.Column - 1
+
+	for i := start; i < len(lines); i++ {
+		switch {
+		case i == start && column <= len(lines[i]):
+			lines[i] = lines[i][:column] + "- " + lines[i][column:]
+		case lines[i] != "":
+			lines[i] = "  " + lines[i]
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Returns the key-value pairs of a block-style mapping
+func mappingValues(node ast.Node) ([]*ast.MappingValueNode, bool) {
+	switch n := node.(type) {
+	case *ast.MappingNode:
+		if n.IsFlowStyle {
+			return nil, false
+		}
+		return n.Values, true
+	case *ast.MappingValueNode:
+		return []*ast.MappingValueNode{n}, true
+	}
+	return nil, false
+}
+
+// Replaces a range of lines; an empty range is an insertion
+type textEdit struct {
+	start int // First line, 0-based
+	end   int // Line after the range
+	lines []string
+	order int // Recording order, so insertions at one line keep their order
+}
+
+// Records line edits against the original text
+type textEditor struct {
+	lines []string
+	edits []textEdit
+}
+
+func newTextEditor(data []byte) *textEditor {
+	return &textEditor{lines: strings.Split(string(data), "\n")}
+}
+
+// Records a replacement of lines [start, end)
+func (e *textEditor) replace(start, end int, lines []string) {
+	e.edits = append(e.edits, textEdit{start: start, end: end, lines: lines, order: len(e.edits)})
+}
+
+// Applies all recorded edits and returns the resulting text
+func (e *textEditor) apply() []byte {
+	edits := append([]textEdit(nil), e.edits...)
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start > edits[j].start
+		}
+		return edits[i].order > edits[j].order
+	})
+
+	lines := append([]string(nil), e.lines...)
+	for _, edit := range edits {
+		updated := make([]string, 0, len(lines)-(edit.end-edit.start)+len(edit.lines))
+		updated = append(updated, lines[:edit.start]...)
+		updated = append(updated, edit.lines...)
+		updated = append(updated, lines[edit.end:]...)
+		lines = updated
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Applies a processed task list to a sequence of tasks
+func (e *textEditor) editSequence(seq *ast.SequenceNode, edit TaskListEdit) error {
+	if len(edit.Origins) != len(edit.Tasks) {
+		return fmt.Errorf("task origins do not match tasks")
+	}
+
+	used := make(map[int]bool)
+	var pending []interface{}
+	lastEnd, lastIndent := -1, 0
+
+	for i, task := range edit.Tasks {
+		origin := edit.Origins[i]
+		if origin.Index < 0 {
+			pending = append(pending, task)
+			continue
+		}
+
+		if origin.Index >= len(seq.Values) {
+			return fmt.Errorf("task origin %d out of range", origin.Index)
+		}
+		entry := seq.Values[origin.Index]
+		used[origin.Index] = true
+
+		dashLine, dashIndent, err := e.entryStart(entry)
+		if err != nil {
+			return err
+		}
+
+		// Injected tasks go right before the task that follows them
+		if len(pending) > 0 {
+			e.replace(dashLine, dashLine, marshalTasks(pending, dashIndent))
+			pending = nil
+		}
+
+		if err := e.editEntry(entry, task, origin); err != nil {
+			return err
+		}
+
+		lastEnd, lastIndent = e.entryEnd(entry, dashLine), dashIndent
+	}
+
+	// Tasks dropped by processing are removed
+	for index, entry := range seq.Values {
+		if used[index] {
+			continue
+		}
+		dashLine, _, err := e.entryStart(entry)
+		if err != nil {
+			return err
+		}
+		e.replace(dashLine, e.entryEnd(entry, dashLine), nil)
+	}
+
+	if len(pending) > 0 {
+		if lastEnd < 0 {
+			return fmt.Errorf("cannot append tasks to an empty task list")
+		}
+		e.replace(lastEnd, lastEnd, marshalTasks(pending, lastIndent))
+	}
+
+	return nil
+}
+
+// Applies a processed task to its original entry
+func (e *textEditor) editEntry(entry ast.Node, task map[string]interface{}, origin TaskOrigin) error {
+	values, ok := mappingValues(entry)
+	if !ok {
+		return fmt.Errorf("task at line %d is not a block mapping", entry.GetToken().Position.Line)
+	}
+
+	if origin.Sections != nil {
+		return e.editSections(values, origin.Sections)
+	}
+
+	var original map[string]interface{}
+	if err := yaml.NodeToValue(entry, &original); err != nil {
+		return fmt.Errorf("decoding task at line %d: %w", entry.GetToken().Position.Line, err)
+	}
+
+	if equalValues(original, task) {
+		return nil
+	}
+
+	return e.editMapping(values, original, task)
+}
+
+// Applies processed task lists to the sections of a block or play
+func (e *textEditor) editSections(values []*ast.MappingValueNode, sections map[string]TaskListEdit) error {
+	for _, kv := range values {
+		section, ok := sections[kv.Key.GetToken().Value]
+		if !ok {
+			continue
+		}
+
+		seq, ok := kv.Value.(*ast.SequenceNode)
+		if !ok || seq.IsFlowStyle {
+			return fmt.Errorf("section %s at line %d is not a block sequence",
+				kv.Key.GetToken().Value, kv.Key.GetToken().Position.Line)
+		}
+
+		if err := e.editSequence(seq, section); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Edits a mapping so that it matches updated, touching only changed keys
+func (e *textEditor) editMapping(values []*ast.MappingValueNode, original, updated map[string]interface{}) error {
+	if len(values) == 0 {
+		return fmt.Errorf("cannot edit an empty mapping")
+	}
+
+	indent := values[0].Key.GetToken().Position.Column - 1
+	seen := make(map[string]bool)
+
+	for i, kv := range values {
+		key := kv.Key.GetToken().Value
+		seen[key] = true
+		start, end := e.keyValueRange(values, i)
+
+		newValue, keep := updated[key]
+		if !keep {
+			e.replace(start, end, nil)
+			continue
+		}
+
+		if equalValues(original[key], newValue) {
+			continue
+		}
+
+		if err := e.replaceValue(kv, start, end, original[key], newValue); err != nil {
+			return err
+		}
+	}
+
+	// New keys are appended after the last key
+	var added []string
+	for key := range updated {
+		if !seen[key] {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+
+	if len(added) > 0 {
+		_, end := e.keyValueRange(values, len(values)-1)
+		var lines []string
+		for _, key := range added {
+			lines = append(lines, marshalLines(map[string]interface{}{key: updated[key]}, indent)...)
+		}
+		e.replace(end, end, lines)
+	}
+
+	return nil
+}
+
+// Replaces the value of a key, editing nested mappings and inline scalars in place
+func (e *textEditor) replaceValue(kv *ast.MappingValueNode, start, end int, oldValue, newValue interface{}) error {
+	keyPos := kv.Key.GetToken().Position
+	valuePos := kv.Value.GetToken().Position
+
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap && valuePos.Line > keyPos.Line {
+		if nested, ok := mappingValues(kv.Value); ok {
+			return e.editMapping(nested, oldMap, newMap)
+		}
+	}
+
+	// Single-line scalar: replace just the value, keeping any trailing comment
+	if isScalarNode(kv.Value) && isScalarValue(newValue) && valuePos.Line == keyPos.Line && end == start+1 {
+		line := e.lines[start]
+		valueStart := valuePos.Column - 1
+		valueEnd := scalarEnd(line, valueStart)
+		if valueStart < len(line) && valueEnd > valueStart {
+			e.replace(start, end, []string{line[:valueStart] + marshalScalar(newValue, line[valueStart]) + line[valueEnd:]})
+			return nil
+		}
+	}
+
+	// Anything else: rewrite the whole key
+	key := kv.Key.GetToken().Value
+	e.replace(start, end, marshalLines(map[string]interface{}{key: newValue}, keyPos.Column-1))
+	return nil
+}
+
+// Returns the first line of a sequence entry (the dash line) and the dash indentation
+func (e *textEditor) entryStart(entry ast.Node) (int, int, error) {
+	values, ok := mappingValues(entry)
+	if !ok || len(values) == 0 {
+		return 0, 0, fmt.Errorf("task at line %d is not a block mapping", entry.GetToken().Position.Line)
+	}
+
+	keyPos := values[0].Key.GetToken().Position
+	line := keyPos.Line - 1
+	prefix := e.lines[line]
+	if keyPos.Column-1 <= len(prefix) {
+		prefix = prefix[:keyPos.Column-1]
+	}
+
+	// "- key: value" on one line
+	if dash := strings.LastIndex(prefix, "-"); dash >= 0 && strings.TrimSpace(prefix) == "-" {
+		return line, dash, nil
+	}
+
+	// A lone dash on the line before the first key
+	for prev := line - 1; prev >= 0; prev-- {
+		trimmed := strings.TrimSpace(e.lines[prev])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if trimmed == "-" {
+			return prev, strings.Index(e.lines[prev], "-"), nil
+		}
+		break
+	}
+
+	return 0, 0, fmt.Errorf("cannot locate task entry at line %d", keyPos.Line)
+}
+
+// Returns the line after the last line of a sequence entry
+func (e *textEditor) entryEnd(entry ast.Node, dashLine int) int {
+	values, ok := mappingValues(entry)
+	if !ok || len(values) == 0 {
+		return dashLine + 1
+	}
+	_, end := e.keyValueRange(values, len(values)-1)
+	return end
+}
+
+// Returns the line range of the i-th key-value pair of a mapping,
+// excluding trailing blank and comment lines
+func (e *textEditor) keyValueRange(values []*ast.MappingValueNode, i int) (int, int) {
+	keyPos := values[i].Key.GetToken().Position
+	start := keyPos.Line - 1
+	keyIndent := keyPos.Column - 1
+
+	limit := len(e.lines)
+	if i+1 < len(values) {
+		limit = values[i+1].Key.GetToken().Position.Line - 1
+	}
+
+	_, valueIsSequence := values[i].Value.(*ast.SequenceNode)
+
+	end := start + 1
+	for end < limit && isContinuationLine(e.lines[end], keyIndent, valueIsSequence) {
+		end++
+	}
+
+	// Trailing blank lines and comments belong to what follows
+	for end > start+1 {
+		trimmed := strings.TrimSpace(e.lines[end-1])
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+		end--
+	}
+
+	return start, end
+}
+
+// Checks if a line still belongs to the value of a key at keyIndent
+func isContinuationLine(line string, keyIndent int, valueIsSequence bool) bool {
+	trimmed := strings.TrimSpace(line)
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+
+	switch {
+	case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		return true
+	case indent > keyIndent:
+		return true
+	case indent == keyIndent && valueIsSequence:
+		// Sequence items may sit at the same indentation as their key
+		return trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+	}
+	return false
+}
+
+// Checks if a node is a scalar
+func isScalarNode(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.StringNode, *ast.IntegerNode, *ast.FloatNode, *ast.BoolNode, *ast.NullNode:
+		return true
+	}
+	return false
+}
+
+// Checks if a value is a scalar
+func isScalarValue(value interface{}) bool {
+	switch value.(type) {
+	case string, bool, int, int64, uint64, float64:
+		return true
+	}
+	return false
+}
+
+// Returns the column after a single-line scalar starting at start
+func scalarEnd(line string, start int) int {
+	if start >= len(line) {
+		return start
+	}
+
+	switch quote := line[start]; quote {
+	case '"', '\'':
+		for i := start + 1; i < len(line); i++ {
+			switch {
+			case quote == '"' && line[i] == '\\':
+				i++
+			case quote == '\'' && line[i] == '\'' && i+1 < len(line) && line[i+1] == '\'':
+				i++
+			case line[i] == quote:
+				return i + 1
+			}
+		}
+		return len(line)
+	}
+
+	// Plain scalar: ends at a comment or the end of the line
+	end := len(line)
+	if comment := strings.Index(line[start:], " #"); comment >= 0 {
+		end = start + comment
+	}
+	return start + len(strings.TrimRight(line[start:end], " \t"))
+}
+
+// Marshals a scalar value to its YAML text, keeping the quote style of the
+// value it replaces where the new value allows it
+func marshalScalar(value interface{}, quote byte) string {
+	if str, ok := value.(string); ok && !strings.ContainsAny(str, "\n\\") {
+		switch {
+		case quote == '\'':
+			return "'" + strings.ReplaceAll(str, "'", "''") + "'"
+		case quote == '"':
+			return "\"" + strings.ReplaceAll(str, "\"", "\\\"") + "\""
+		}
+	}
+
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return strings.TrimRight(string(data), "\n")
+}
+
+// Marshals a value to YAML lines indented by indent spaces
+func marshalLines(value interface{}, indent int) []string {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return nil
+	}
+
+	prefix := strings.Repeat(" ", indent)
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if line == "" {
+			lines = append(lines, line)
+			continue
+		}
+		lines = append(lines, prefix+line)
+	}
+	return lines
+}
+
+// Marshals injected tasks as a sequence, with each task's name first
+func marshalTasks(tasks []interface{}, indent int) []string {
+	ordered := make([]interface{}, 0, len(tasks))
+	for _, task := range tasks {
+		taskMap, ok := task.(map[string]interface{})
+		if !ok {
+			ordered = append(ordered, task)
+			continue
+		}
+
+		keys := make([]string, 0, len(taskMap))
+		for key := range taskMap {
+			if key != "name" {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		var slice yaml.MapSlice
+		if name, ok := taskMap["name"]; ok {
+			slice = append(slice, yaml.MapItem{Key: "name", Value: name})
+		}
+		for _, key := range keys {
+			slice = append(slice, yaml.MapItem{Key: key, Value: taskMap[key]})
+		}
+		ordered = append(ordered, slice)
+	}
+
+	return marshalLines(ordered, indent)
+}
+
+// Compares decoded YAML values, ignoring differences in numeric types
+func equalValues(a, b interface{}) bool {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return string(dataA) == string(dataB)
+}
@@ -0,0 +1,190 @@
+package ansible
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+func TestRewriteTaskFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		edit     func(tasks []map[string]interface{}) TaskListEdit
+		expected string
+	}{
+		{
+			name: "unchanged tasks are kept verbatim",
+			original: `# Comment
+- name: "Install"   # inline
+  apt: name=app state=present
+`,
+			edit: func(tasks []map[string]interface{}) TaskListEdit {
+				return TaskListEdit{Tasks: tasks, Origins: []TaskOrigin{{Index: 0}}}
+			},
+			expected: `# Comment
+- name: "Install"   # inline
+  apt: name=app state=present
+`,
+		},
+		{
+			name: "existing tags and run_once are edited in place",
+			original: `- name: Render
+  template:
+    src: a.j2
+    dest: /etc/a.conf   # target
+  run_once: false
+  tags: [config]
+  notify: restart
+`,
+			edit: func(tasks []map[string]interface{}) TaskListEdit {
+				ModifyTemplateTask(tasks[0], RenderOptions{})
+				return TaskListEdit{Tasks: tasks, Origins: []TaskOrigin{{Index: 0}}}
+			},
+			expected: `- name: Render
+  template:
+    src: a.j2
+    dest: output/etc/a.conf   # target
+  run_once: true
+  tags:
+  - config
+  - render_config
+  delegate_to: localhost
+`,
+		},
+		{
+			name: "a single task file becomes a list",
+			original: `---
+# Only task
+name: Render
+template:
+  src: a.j2
+  dest: /etc/a.conf
+`,
+			edit: func(tasks []map[string]interface{}) TaskListEdit {
+				injected := map[string]interface{}{"debug": map[string]interface{}{"msg": "before"}}
+				ModifyTemplateTask(tasks[0], RenderOptions{})
+				return TaskListEdit{
+					Tasks:   []map[string]interface{}{injected, tasks[0]},
+					Origins: []TaskOrigin{InjectedTask(), {Index: 0}},
+				}
+			},
+			expected: `---
+# Only task
+- debug:
+    msg: before
+- name: Render
+  template:
+    src: a.j2
+    dest: output/etc/a.conf
+  delegate_to: localhost
+  run_once: true
+  tags:
+  - render_config
+`,
+		},
+		{
+			name: "per-host mode removes run_once",
+			original: `tasks:
+  - template:
+      src: a.j2
+      dest: /etc/a.conf
+    run_once: yes
+`,
+			edit: func(tasks []map[string]interface{}) TaskListEdit {
+				ModifyTemplateTask(tasks[0], RenderOptions{PerHost: true})
+				return TaskListEdit{Tasks: tasks, Origins: []TaskOrigin{{Index: 0}}}
+			},
+			expected: `tasks:
+  - template:
+      src: a.j2
+      dest: output/{{ inventory_hostname }}/etc/a.conf
+    delegate_to: localhost
+    tags:
+    - render_config
+`,
+		},
+		{
+			name: "injected tasks are placed before their task",
+			original: `- debug: msg=one
+- command: "true"
+`,
+			edit: func(tasks []map[string]interface{}) TaskListEdit {
+				injected := map[string]interface{}{"name": "Injected", "debug": map[string]interface{}{"msg": "two"}}
+				return TaskListEdit{
+					Tasks:   []map[string]interface{}{tasks[0], injected, tasks[1]},
+					Origins: []TaskOrigin{{Index: 0}, InjectedTask(), {Index: 1}},
+				}
+			},
+			expected: `- debug: msg=one
+- name: Injected
+  debug:
+    msg: two
+- command: "true"
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks := loadTestTasks(t, tt.original)
+
+			result, err := RewriteTaskFile([]byte(tt.original), tt.edit(tasks))
+			if err != nil {
+				t.Fatalf("RewriteTaskFile() error = %v", err)
+			}
+
+			if string(result) != tt.expected {
+				t.Errorf("RewriteTaskFile() =\n%s\nwant\n%s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRewriteTaskFile_UnsupportedLayout(t *testing.T) {
+	original := `[{template: {src: a.j2, dest: /etc/a.conf}}]`
+	tasks := loadTestTasks(t, original)
+
+	_, err := RewriteTaskFile([]byte(original), TaskListEdit{Tasks: tasks, Origins: []TaskOrigin{{Index: 0}}})
+	if err == nil {
+		t.Errorf("RewriteTaskFile() should fail for flow-style task lists")
+	}
+}
+
+func TestSaveTaskFileFrom_UnsupportedLayout(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "main.yml")
+	original := "# Keep this comment\n[{template: {src: a.j2, dest: /etc/a.conf}}]\n"
+	if err := os.WriteFile(sourcePath, []byte(original), 0644); err != nil {
+		t.Fatalf("Failed to write task file: %v", err)
+	}
+
+	tasks := loadTestTasks(t, original)
+	ModifyTemplateTask(tasks[0], RenderOptions{})
+
+	// Re-serializing would silently drop the comment, so saving fails instead
+	destPath := filepath.Join(dir, "out", "main.yml")
+	err := SaveTaskFileFrom(sourcePath, TaskListEdit{Tasks: tasks, Origins: []TaskOrigin{{Index: 0}}}, destPath)
+	if err == nil {
+		t.Fatalf("SaveTaskFileFrom() should fail for flow-style task lists")
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Errorf("SaveTaskFileFrom() wrote %s despite failing", destPath)
+	}
+}
+
+// Decodes a task list the way LoadTaskFile does
+func loadTestTasks(t *testing.T, content string) []map[string]interface{} {
+	var tasks []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(content), &tasks); err == nil {
+		return tasks
+	}
+
+	tasks, err := parseSingleTaskOrContainer([]byte(content))
+	if err != nil {
+		t.Fatalf("Failed to decode tasks: %v", err)
+	}
+	return tasks
+}
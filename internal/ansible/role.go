@@ -1,8 +1,11 @@
 package ansible
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/zinrai/ansible-template-render/internal/finder"
 	"github.com/zinrai/ansible-template-render/internal/logger"
@@ -20,29 +23,107 @@ type RoleMeta struct {
 	Dependencies []interface{} `yaml:"dependencies"`
 }
 
+// Module keys that include or import a role from a task
+var roleIncludeKeys = []string{
+	"include_role",
+	"import_role",
+	"ansible.builtin.include_role",
+	"ansible.builtin.import_role",
+}
+
+// Represents a role pulled in by include_role or import_role
+type RoleInclude struct {
+	Name         string
+	TasksFrom    string
+	VarsFrom     string
+	DefaultsFrom string
+}
+
 // Resolves role dependencies
-type RoleDependencyResolver struct{}
+type RoleDependencyResolver struct {
+	Paths finder.SearchPaths // Where roles and collections are looked up
+}
 
-// Gets the dependencies of a role
+// Gets the dependencies of a role, including roles it includes or imports from its tasks
 func (r *RoleDependencyResolver) GetDependencies(roleName string) ([]string, error) {
-	metaPath, exists, err := finder.FindRoleMetaFile(roleName)
+	metaPath, exists, err := r.Paths.FindRoleMetaFile(roleName)
 	if err != nil {
 		return nil, err
 	}
 
-	// No meta file means no dependencies
-	if !exists {
-		return []string{}, nil
-	}
+	dependencies := []string{}
+
+	// No meta file means no meta dependencies
+	if exists {
+		// Read and parse meta file
+		meta, err := r.loadRoleMeta(metaPath)
+		if err != nil {
+			return nil, err
+		}
 
-	// Read and parse meta file
-	meta, err := r.loadRoleMeta(metaPath)
+		// Extract dependencies
+		dependencies = append(dependencies, r.extractDependencies(meta.Dependencies)...)
+	}
+	includedRoles, err := r.getIncludedRoles(roleName)
 	if err != nil {
 		return nil, err
 	}
+	dependencies = append(dependencies, includedRoles...)
+
+	collections := roleCollections(roleName)
+	for i, dep := range dependencies {
+		dependencies[i] = r.Paths.QualifyRoleName(dep, collections)
+	}
+
+	return dependencies, nil
+}
+
+// Returns the collections searched for short role names used inside a role;
+// a collection role refers to its own collection first
+func roleCollections(roleName string) []string {
+	role, ok := finder.ParseCollectionRole(roleName)
+	if !ok {
+		return nil
+	}
+	return []string{role.CollectionName()}
+}
+
+// Gets the roles included or imported from a role's task files, and from
+// the task files they include or import from outside the tasks directory
+func (r *RoleDependencyResolver) getIncludedRoles(roleName string) ([]string, error) {
+	taskFiles, err := r.Paths.FindRoleTasks(roleName)
+	if err != nil {
+		logger.Warn("Error finding role tasks", "role", roleName, "error", err)
+		return nil, nil
+	}
+	if len(taskFiles) == 0 {
+		return nil, nil
+	}
+
+	rolePath, err := r.Paths.FindRolePath(roleName)
+	if err != nil {
+		logger.Warn("Error finding role", "role", roleName, "error", err)
+		return nil, nil
+	}
+
+	var roles []string
+	for _, taskFile := range FollowTaskIncludes(taskFiles, filepath.Join(rolePath, "tasks")) {
+		tasks, err := LoadTaskFile(taskFile)
+		if err != nil {
+			logger.Warn("Error loading task file", "file", taskFile, "error", err)
+			continue
+		}
 
-	// Extract dependencies
-	return r.extractDependencies(meta.Dependencies), nil
+		for _, include := range ExtractRoleIncludes(tasks) {
+			include.Name = r.Paths.QualifyRoleName(include.Name, roleCollections(roleName))
+			if err := CheckRoleIncludeFiles(include, r.Paths); err != nil {
+				return nil, fmt.Errorf("%s: %w", taskFile, err)
+			}
+			roles = append(roles, include.Name)
+		}
+	}
+
+	return roles, nil
 }
 
 // Loads and parses a role's meta file
@@ -104,13 +185,13 @@ func (r *RoleDependencyResolver) extractDependencies(dependencies []interface{})
 }
 
 // Gets the dependencies of a role
-func GetRoleDependencies(roleName string) ([]string, error) {
-	resolver := RoleDependencyResolver{}
+func GetRoleDependencies(roleName string, paths finder.SearchPaths) ([]string, error) {
+	resolver := RoleDependencyResolver{Paths: paths}
 	return resolver.GetDependencies(roleName)
 }
 
 // Recursively resolves role dependencies
-func ResolveRoleDependencies(roleName string, resolved map[string]bool) ([]string, error) {
+func ResolveRoleDependencies(roleName string, paths finder.SearchPaths, resolved map[string]bool) ([]string, error) {
 	// Skip already resolved roles
 	if resolved[roleName] {
 		return nil, nil
@@ -120,7 +201,10 @@ func ResolveRoleDependencies(roleName string, resolved map[string]bool) ([]strin
 	resolved[roleName] = true
 
 	// Get role dependencies
-	dependencies, err := GetRoleDependencies(roleName)
+	dependencies, err := GetRoleDependencies(roleName, paths)
+	if errors.Is(err, ErrRoleEntryNotFound) {
+		return nil, err
+	}
 	if err != nil {
 		logger.Warn("Error getting dependencies", "role", roleName, "error", err)
 		dependencies = []string{} // Continue with no dependencies on error
@@ -129,10 +213,9 @@ func ResolveRoleDependencies(roleName string, resolved map[string]bool) ([]strin
 	// Resolve dependencies recursively
 	var allRoles []string
 	for _, dep := range dependencies {
-		depRoles, err := ResolveRoleDependencies(dep, resolved)
+		depRoles, err := ResolveRoleDependencies(dep, paths, resolved)
 		if err != nil {
-			logger.Warn("Error resolving dependencies", "role", dep, "error", err)
-			continue // Skip this dependency on error
+			return nil, err
 		}
 		allRoles = append(allRoles, depRoles...)
 	}
@@ -142,3 +225,90 @@ func ResolveRoleDependencies(roleName string, resolved map[string]bool) ([]strin
 
 	return allRoles, nil
 }
+
+// Extracts include_role and import_role references from a task list,
+// descending into block, rescue and always sections
+func ExtractRoleIncludes(tasks []map[string]interface{}) []RoleInclude {
+	var includes []RoleInclude
+
+	for _, task := range tasks {
+		if include, ok := roleIncludeFromTask(task); ok {
+			includes = append(includes, include)
+		}
+
+		for _, section := range BlockSections {
+			tasksList, ok := task[section].([]interface{})
+			if !ok {
+				continue
+			}
+
+			sectionTasks, _ := convertTasksList(tasksList)
+			includes = append(includes, ExtractRoleIncludes(sectionTasks)...)
+		}
+	}
+
+	return includes
+}
+
+// Returns the role referenced by an include_role or import_role task
+func roleIncludeFromTask(task map[string]interface{}) (RoleInclude, bool) {
+	for _, key := range roleIncludeKeys {
+		value, exists := task[key]
+		if !exists {
+			continue
+		}
+
+		// Both the parameter and the free-form name=... forms are accepted
+		params, ok := moduleArgs(value)
+		if !ok {
+			logger.Warn("Skipping role include with unreadable arguments", "module", key)
+			return RoleInclude{}, false
+		}
+
+		name, _ := params["name"].(string)
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return RoleInclude{}, false
+		}
+
+		if strings.Contains(name, "{{") {
+			logger.Warn("Skipping templated role include", "role", name)
+			return RoleInclude{}, false
+		}
+
+		include := RoleInclude{Name: name}
+		include.TasksFrom, _ = params["tasks_from"].(string)
+		include.VarsFrom, _ = params["vars_from"].(string)
+		include.DefaultsFrom, _ = params["defaults_from"].(string)
+		return include, true
+	}
+
+	return RoleInclude{}, false
+}
+
+// Reported when a tasks_from, vars_from or defaults_from file is missing from its role
+var ErrRoleEntryNotFound = errors.New("role entry file not found")
+
+// Checks that the tasks_from, vars_from and defaults_from files exist in the role
+func CheckRoleIncludeFiles(include RoleInclude, paths finder.SearchPaths) error {
+	entries := []struct {
+		dir  string
+		name string
+	}{
+		{"tasks", include.TasksFrom},
+		{"vars", include.VarsFrom},
+		{"defaults", include.DefaultsFrom},
+	}
+
+	for _, entry := range entries {
+		if entry.name == "" || strings.Contains(entry.name, "{{") {
+			continue
+		}
+
+		if _, found := paths.FindRoleEntryFile(include.Name, entry.dir, entry.name); !found {
+			return fmt.Errorf("%w: role %s has no %s file %q", ErrRoleEntryNotFound, include.Name, entry.dir, entry.name)
+		}
+	}
+
+	return nil
+}
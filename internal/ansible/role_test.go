@@ -1,9 +1,13 @@
 package ansible
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/zinrai/ansible-template-render/internal/finder"
 )
 
 func TestRoleDependencyResolver_extractDependencies(t *testing.T) {
@@ -167,7 +171,7 @@ dependencies: []
 
 	// Test resolving role1's dependencies
 	resolved := make(map[string]bool)
-	roles, err := ResolveRoleDependencies("role1", resolved)
+	roles, err := ResolveRoleDependencies("role1", finder.SearchPaths{}, resolved)
 	if err != nil {
 		t.Fatalf("ResolveRoleDependencies() error = %v", err)
 	}
@@ -220,6 +224,157 @@ dependencies: []
 	}
 }
 
+func TestExtractRoleIncludes(t *testing.T) {
+	tasks := []map[string]interface{}{
+		{
+			"include_role": map[string]interface{}{
+				"name":       "role1",
+				"tasks_from": "install",
+			},
+		},
+		{
+			"block": []interface{}{
+				map[string]interface{}{
+					"ansible.builtin.import_role": map[string]interface{}{
+						"name":          "role2",
+						"vars_from":     "debian",
+						"defaults_from": "small",
+					},
+				},
+			},
+		},
+		{
+			"include_role": map[string]interface{}{
+				"name": "{{ dynamic_role }}",
+			},
+		},
+		{"include_role": "name=role3 tasks_from=setup"},
+		{"command": "echo hello"},
+	}
+
+	expected := []RoleInclude{
+		{Name: "role1", TasksFrom: "install"},
+		{Name: "role2", VarsFrom: "debian", DefaultsFrom: "small"},
+		{Name: "role3", TasksFrom: "setup"},
+	}
+
+	result := ExtractRoleIncludes(tasks)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ExtractRoleIncludes() = %v, want %v", result, expected)
+	}
+}
+
+func TestResolveRoleDependencies_IncludedRoles(t *testing.T) {
+	helper := NewTestPlaybookHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateRoleDirectory(t, "app")
+	helper.CreateRoleDirectory(t, "nginx")
+	helper.CreateRoleDirectory(t, "certs")
+
+	// app includes nginx from its tasks; nginx depends on certs through meta
+	helper.CreateTaskFile(t, "app", "main.yml", `---
+- include_role:
+    name: nginx
+    tasks_from: vhost
+`)
+	helper.CreateTaskFile(t, "nginx", "vhost.yml", `---
+- debug:
+    msg: vhost
+`)
+	helper.CreateMetaFile(t, "nginx", `---
+dependencies:
+  - certs
+`)
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	defer os.Chdir(currentDir)
+
+	os.Chdir(helper.TempDir)
+
+	resolved := make(map[string]bool)
+	roles, err := ResolveRoleDependencies("app", finder.SearchPaths{}, resolved)
+	if err != nil {
+		t.Fatalf("ResolveRoleDependencies() error = %v", err)
+	}
+
+	expected := []string{"certs", "nginx", "app"}
+	if !reflect.DeepEqual(roles, expected) {
+		t.Errorf("ResolveRoleDependencies() = %v, want %v", roles, expected)
+	}
+}
+
+func TestResolveRoleDependencies_RolesIncludedFromOutsideTasks(t *testing.T) {
+	helper := NewTestPlaybookHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateRoleDirectory(t, "app")
+	helper.CreateRoleDirectory(t, "nginx")
+
+	// app includes a shared task file outside its tasks directory, which
+	// includes nginx in free-form
+	helper.CreateTaskFile(t, "app", "main.yml", `---
+- include_tasks: ../shared/web.yml
+`)
+	sharedPath := filepath.Join(helper.TempDir, "roles", "app", "shared", "web.yml")
+	if err := os.MkdirAll(filepath.Dir(sharedPath), 0755); err != nil {
+		t.Fatalf("Failed to create shared directory: %v", err)
+	}
+	if err := os.WriteFile(sharedPath, []byte("- include_role: name=nginx\n"), 0644); err != nil {
+		t.Fatalf("Failed to create shared task file: %v", err)
+	}
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	defer os.Chdir(currentDir)
+
+	os.Chdir(helper.TempDir)
+
+	resolved := make(map[string]bool)
+	roles, err := ResolveRoleDependencies("app", finder.SearchPaths{}, resolved)
+	if err != nil {
+		t.Fatalf("ResolveRoleDependencies() error = %v", err)
+	}
+
+	expected := []string{"nginx", "app"}
+	if !reflect.DeepEqual(roles, expected) {
+		t.Errorf("ResolveRoleDependencies() = %v, want %v", roles, expected)
+	}
+}
+
+func TestResolveRoleDependencies_MissingEntryFile(t *testing.T) {
+	helper := NewTestPlaybookHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateRoleDirectory(t, "app")
+	helper.CreateRoleDirectory(t, "nginx")
+
+	helper.CreateTaskFile(t, "app", "main.yml", `---
+- include_role:
+    name: nginx
+    tasks_from: missing
+`)
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	defer os.Chdir(currentDir)
+
+	os.Chdir(helper.TempDir)
+
+	resolved := make(map[string]bool)
+	_, err = ResolveRoleDependencies("app", finder.SearchPaths{}, resolved)
+	if !errors.Is(err, ErrRoleEntryNotFound) {
+		t.Errorf("ResolveRoleDependencies() error = %v, want %v", err, ErrRoleEntryNotFound)
+	}
+}
+
 // Provides utilities for testing with Ansible playbooks
 type TestPlaybookHelper struct {
 	TempDir string
@@ -270,3 +425,13 @@ func (h *TestPlaybookHelper) CreateMetaFile(t *testing.T, roleName, content stri
 	}
 	return metaPath
 }
+
+// Creates a task file for a role
+func (h *TestPlaybookHelper) CreateTaskFile(t *testing.T, roleName, fileName, content string) string {
+	taskPath := filepath.Join(h.TempDir, "roles", roleName, "tasks", fileName)
+	err := os.WriteFile(taskPath, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Failed to create task file: %v", err)
+	}
+	return taskPath
+}
@@ -4,10 +4,192 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/zinrai/ansible-template-render/internal/finder"
+	"github.com/zinrai/ansible-template-render/internal/logger"
 
 	"github.com/goccy/go-yaml"
 )
 
+// Sections of a block task that hold task lists
+var BlockSections = []string{"block", "rescue", "always"}
+
+// Determines if a task is a block
+func IsBlockTask(task map[string]interface{}) bool {
+	_, hasBlock := task["block"]
+	return hasBlock
+}
+
+// Task keywords that decide for which items, and whether, a task runs
+var iterationKeywords = []string{"loop", "loop_control", "when", "vars"}
+
+// Determines if a task loops, with loop or one of the with_* keywords
+func IsLoopTask(task map[string]interface{}) bool {
+	for key := range task {
+		if key == "loop" || strings.HasPrefix(key, "with_") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the keywords a task needs to run for the same items as the given
+// task: its loop, loop_control, when and vars
+func IterationKeywords(task map[string]interface{}) map[string]interface{} {
+	keywords := make(map[string]interface{})
+	for key, value := range task {
+		if strings.HasPrefix(key, "with_") {
+			keywords[key] = value
+		}
+	}
+	for _, key := range iterationKeywords {
+		if value, ok := task[key]; ok {
+			keywords[key] = value
+		}
+	}
+	return keywords
+}
+
+// Module keys that include or import another task file
+var taskIncludeKeys = []string{
+	"include_tasks",
+	"import_tasks",
+	"ansible.builtin.include_tasks",
+	"ansible.builtin.import_tasks",
+	"include",
+	"ansible.builtin.include",
+}
+
+// Module keys that include a task file or role at run time. Their tags
+// apply to the include itself, not to the tasks it pulls in.
+var dynamicIncludeKeys = []string{
+	"include_tasks",
+	"ansible.builtin.include_tasks",
+	"include",
+	"ansible.builtin.include",
+	"include_role",
+	"ansible.builtin.include_role",
+}
+
+// Determines if a task includes a task file or role at run time
+func IsDynamicIncludeTask(task map[string]interface{}) bool {
+	for _, key := range dynamicIncludeKeys {
+		if _, ok := task[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Modifies a dynamic include to run as part of the render_config tag, so
+// the rewritten tasks it pulls in, which carry the tag themselves, are
+// reached. The tag is deliberately not applied to the included tasks:
+// every other task in them would run as well.
+func ModifyIncludeTask(task map[string]interface{}) {
+	ensureRenderConfigTag(task)
+}
+
+// Extracts the task file paths included or imported by a task list,
+// descending into block, rescue and always sections
+func ExtractTaskIncludes(tasks []map[string]interface{}) []string {
+	var includes []string
+
+	for _, task := range tasks {
+		if includePath := taskIncludePath(task); includePath != "" {
+			includes = append(includes, includePath)
+		}
+
+		for _, section := range BlockSections {
+			tasksList, ok := task[section].([]interface{})
+			if !ok {
+				continue
+			}
+
+			sectionTasks, _ := convertTasksList(tasksList)
+			includes = append(includes, ExtractTaskIncludes(sectionTasks)...)
+		}
+	}
+
+	return includes
+}
+
+// Returns the task file referenced by an include or import task
+func taskIncludePath(task map[string]interface{}) string {
+	for _, key := range taskIncludeKeys {
+		switch value := task[key].(type) {
+		case string:
+			return strings.TrimSpace(value)
+		case map[string]interface{}:
+			// Parameter form: include_tasks: {file: path}
+			if file, ok := value["file"].(string); ok {
+				return strings.TrimSpace(file)
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the task files, each followed by the task files it includes or
+// imports, without duplicates. Includes are looked up next to the including
+// file, then in tasksDir.
+func FollowTaskIncludes(taskFiles []string, tasksDir string) []string {
+	var result []string
+	visited := make(map[string]bool)
+
+	queue := taskFiles
+	for len(queue) > 0 {
+		taskFile := filepath.Clean(queue[0])
+		queue = queue[1:]
+
+		if visited[taskFile] {
+			continue
+		}
+		visited[taskFile] = true
+
+		result = append(result, taskFile)
+		queue = append(queue, findIncludedTaskFiles(taskFile, tasksDir)...)
+	}
+
+	return result
+}
+
+// Finds the task files included or imported by a task file
+func findIncludedTaskFiles(taskFile, tasksDir string) []string {
+	tasks, err := LoadTaskFile(taskFile)
+	if err != nil {
+		logger.Warn("Error loading task file for includes", "file", taskFile, "error", err)
+		return nil
+	}
+
+	// Ansible looks next to the including file and in the role's tasks directory
+	searchDirs := []string{filepath.Dir(taskFile), tasksDir}
+
+	return ResolveTaskIncludes(ExtractTaskIncludes(tasks), taskFile, searchDirs)
+}
+
+// Resolves the task files included or imported from a file, searching each
+// directory in order; templated and missing files are skipped
+func ResolveTaskIncludes(includePaths []string, fromFile string, searchDirs []string) []string {
+	var includedFiles []string
+	for _, includePath := range includePaths {
+		if strings.Contains(includePath, "{{") {
+			logger.Warn("Skipping templated task include", "file", fromFile, "include", includePath)
+			continue
+		}
+
+		includedFile, found := finder.FindIncludedTaskFile(includePath, searchDirs)
+		if !found {
+			logger.Warn("Included task file not found", "file", fromFile, "include", includePath)
+			continue
+		}
+
+		includedFiles = append(includedFiles, includedFile)
+	}
+
+	return includedFiles
+}
+
 // Loads a task file
 func LoadTaskFile(path string) ([]map[string]interface{}, error) {
 	data, err := os.ReadFile(path)
@@ -65,21 +247,26 @@ func convertTasksList(tasksList []interface{}) ([]map[string]interface{}, error)
 	return tasks, nil
 }
 
-// Saves a task file
-func SaveTaskFile(tasks []map[string]interface{}, path string) error {
-	// Ensure the output directory exists
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("creating directory: %w", err)
+// Saves a processed task file, editing the original file's text so that only
+// changed keys and injected tasks differ. Fails when the original layout
+// cannot be edited in place: re-serializing would drop comments and change
+// how values are written.
+func SaveTaskFileFrom(sourcePath string, edit TaskListEdit, path string) error {
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("reading task file: %w", err)
 	}
 
-	data, err := yaml.Marshal(tasks)
+	rewritten, err := RewriteTaskFile(data, edit)
 	if err != nil {
-		return fmt.Errorf("marshaling tasks: %w", err)
+		return fmt.Errorf("rewriting task file %s: %w", sourcePath, err)
 	}
 
-	err = os.WriteFile(path, data, 0644)
-	if err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, rewritten, 0644); err != nil {
 		return fmt.Errorf("writing task file: %w", err)
 	}
 
@@ -0,0 +1,41 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractTaskIncludes(t *testing.T) {
+	tasks := []map[string]interface{}{
+		{"include_tasks": "install.yml"},
+		{"ansible.builtin.import_tasks": "subdir/config.yml"},
+		{
+			"ansible.builtin.include_tasks": map[string]interface{}{
+				"file":  "../../shared/nginx.yml",
+				"apply": map[string]interface{}{"tags": []interface{}{"nginx"}},
+			},
+		},
+		{
+			"block": []interface{}{
+				map[string]interface{}{"import_tasks": "nested.yml"},
+			},
+			"rescue": []interface{}{
+				map[string]interface{}{"include_tasks": "rescue.yml"},
+			},
+		},
+		{"command": "echo hello"},
+	}
+
+	expected := []string{
+		"install.yml",
+		"subdir/config.yml",
+		"../../shared/nginx.yml",
+		"nested.yml",
+		"rescue.yml",
+	}
+
+	result := ExtractTaskIncludes(tasks)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("ExtractTaskIncludes() = %v, want %v", result, expected)
+	}
+}
@@ -1,19 +1,79 @@
 package ansible
 
 import (
+	"fmt"
 	"path/filepath"
+	"strings"
+
+	"github.com/zinrai/ansible-template-render/internal/finder"
 )
 
-// Represents a task using the template module
+// Controls how template tasks are rewritten for rendering
+type RenderOptions struct {
+	PlaybookName string
+	OutputDir    string // Directory receiving rendered files, "output" when empty
+	BaselineDir  string // Directory seeding files edited in place; edits are only rendered when set
+	PerHost      bool   // Render for every host into <output>/<inventory_hostname>/
+	RecordsDir   string // Directory receiving the files rendered by rewritten tasks, none when empty
+	Role         string // Role whose tasks are processed, empty for tasks in plays
+	CopySources  bool   // Render copy tasks with a src file on the controller, not only inline content
+
+	// Where roles and collections are looked up
+	SearchPaths finder.SearchPaths
+
+	// Additional modules rewritten like the template module
+	TemplateModules []TemplateModule
+
+	// Directories receiving rendered templates; assemble tasks joining
+	// them are rendered as well
+	RenderedDirs map[string]bool
+}
+
+// Checks if a directory receives rendered templates
+func (o RenderOptions) IsRenderedDir(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	return o.RenderedDirs[filepath.Clean(dir)]
+}
+
+// Returns the baseline path for a destination path
+func (o RenderOptions) BaselinePath(destPath string) string {
+	return filepath.Join(o.BaselineDir, destPath)
+}
+
+// Returns the output path for a destination path
+func (o RenderOptions) OutputPath(destPath string) string {
+	outputDir := o.OutputDir
+	if outputDir == "" {
+		outputDir = "output"
+	}
+
+	if o.PerHost {
+		return filepath.Join(outputDir, "{{ inventory_hostname }}", destPath)
+	}
+	return filepath.Join(outputDir, destPath)
+}
+
+// Module keys of the template module
+var templateModuleKeys = []string{"template", "ansible.builtin.template"}
+
+// Module keys of the copy module
+var copyModuleKeys = []string{"copy", "ansible.builtin.copy"}
+
+// Represents a task that renders a file: the template module, the copy
+// module with inline content or a controller-side source, or a configured
+// template-like module
 type TemplateTask struct {
 	Task       map[string]interface{}
 	ModuleKey  string
 	ModuleData map[string]interface{}
+	DestKey    string // Parameter holding the destination path, "dest" when empty
 }
 
 // Creates a new TemplateTask from a map
-func NewTemplateTask(task map[string]interface{}) (*TemplateTask, bool) {
-	moduleKey, moduleData := findTemplateModule(task)
+func NewTemplateTask(task map[string]interface{}, opts RenderOptions) (*TemplateTask, bool) {
+	moduleKey, moduleData, destKey := findTemplateModule(task, opts)
 	if moduleKey == "" || moduleData == nil {
 		return nil, false
 	}
@@ -22,12 +82,21 @@ func NewTemplateTask(task map[string]interface{}) (*TemplateTask, bool) {
 		Task:       task,
 		ModuleKey:  moduleKey,
 		ModuleData: moduleData,
+		DestKey:    destKey,
 	}, true
 }
 
+// Returns the parameter holding the destination path
+func (t *TemplateTask) destKey() string {
+	if t.DestKey == "" {
+		return "dest"
+	}
+	return t.DestKey
+}
+
 // Returns the destination path of the template
 func (t *TemplateTask) GetDestPath() string {
-	destPath, ok := t.ModuleData["dest"].(string)
+	destPath, ok := t.ModuleData[t.destKey()].(string)
 	if !ok {
 		return ""
 	}
@@ -35,38 +104,96 @@ func (t *TemplateTask) GetDestPath() string {
 }
 
 // Modifies the template task for rendering
-func (t *TemplateTask) Modify(playbookName string) {
-	destPath, ok := t.ModuleData["dest"].(string)
+func (t *TemplateTask) Modify(opts RenderOptions) {
+	destPath, ok := t.ModuleData[t.destKey()].(string)
 	if !ok {
 		return
 	}
 
-	// Use fixed output path based on playbook name
-	t.ModuleData["dest"] = filepath.Join("output", destPath)
+	// Redirect output into the output directory
+	t.ModuleData[t.destKey()] = opts.OutputPath(destPath)
 	t.Task[t.ModuleKey] = t.ModuleData
 
+	renderLocally(t.Task, opts)
+	removeFileAttributes(t.ModuleData)
+
+	// Validation runs locally after rendering, see GetValidateCommand
+	delete(t.ModuleData, "validate")
+}
+
+// Returns the validate command of the template, with %s standing for the file
+func (t *TemplateTask) GetValidateCommand() string {
+	return validateCommand(t.ModuleData)
+}
+
+// Makes a task run on the controller as part of the render_config tag
+func renderLocally(task map[string]interface{}, opts RenderOptions) {
 	// Add render_config tag
-	t.ensureRenderConfigTag()
+	ensureRenderConfigTag(task)
 
 	// Add delegation settings
-	t.Task["delegate_to"] = "localhost"
-	t.Task["run_once"] = true
+	task["delegate_to"] = "localhost"
+	if opts.PerHost {
+		// Every host renders its own copy
+		delete(task, "run_once")
+	} else {
+		task["run_once"] = true
+	}
 
 	// Remove notify field - not needed for configuration rendering
-	delete(t.Task, "notify")
+	delete(task, "notify")
+}
+
+// Removes file attributes - not applicable for local rendering
+func removeFileAttributes(module map[string]interface{}) {
+	delete(module, "owner")
+	delete(module, "group")
+	delete(module, "mode")
+	delete(module, "directory_mode")
+}
 
-	// Remove file attributes - not applicable for local rendering
-	delete(t.ModuleData, "owner")
-	delete(t.ModuleData, "group")
-	delete(t.ModuleData, "mode")
+// Returns the validate command of a module writing a file, which runs
+// locally after rendering instead
+func validateCommand(module map[string]interface{}) string {
+	command, _ := module["validate"].(string)
+	return strings.TrimSpace(command)
+}
+
+// Returns the owner, group and mode a module sets on its file, which are
+// removed for local rendering. Numeric modes are given in octal, as Ansible
+// reads them.
+func FileAttributes(module map[string]interface{}) (owner, group, mode string) {
+	owner = attributeString(module["owner"])
+	group = attributeString(module["group"])
+
+	switch value := module["mode"].(type) {
+	case uint64:
+		mode = fmt.Sprintf("%04o", value)
+	case int64:
+		mode = fmt.Sprintf("%04o", value)
+	case int:
+		mode = fmt.Sprintf("%04o", value)
+	default:
+		mode = attributeString(value)
+	}
+
+	return owner, group, mode
+}
+
+// Formats a file attribute value, empty when unset
+func attributeString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(value))
 }
 
 // Ensures the render_config tag is present
-func (t *TemplateTask) ensureRenderConfigTag() {
-	existingTags, ok := t.Task["tags"].([]interface{})
+func ensureRenderConfigTag(task map[string]interface{}) {
+	existingTags, ok := task["tags"].([]interface{})
 	if !ok {
 		// No tags exist, create new tags array
-		t.Task["tags"] = []interface{}{"render_config"}
+		task["tags"] = []interface{}{"render_config"}
 		return
 	}
 
@@ -76,45 +203,103 @@ func (t *TemplateTask) ensureRenderConfigTag() {
 
 	// Check if render_config tag already exists
 	if hasRenderConfigTag(tags) {
-		t.Task["tags"] = tags
+		task["tags"] = tags
 		return
 	}
 
 	// Add render_config tag
-	t.Task["tags"] = append(tags, "render_config")
+	task["tags"] = append(tags, "render_config")
+}
+
+// Determines if a task renders a file: the template module, the copy
+// module writing inline content or a file from the controller, or a
+// configured template-like module
+func IsTemplateTask(task map[string]interface{}, opts RenderOptions) bool {
+	for _, key := range templateModuleKeys {
+		if _, ok := task[key]; ok {
+			return true
+		}
+	}
+
+	for _, key := range copyModuleKeys {
+		if module, ok := moduleArgs(task[key]); ok && isRenderableCopy(module, opts) {
+			return true
+		}
+	}
+
+	moduleKey, _, _ := findExtraTemplateModule(task, opts.TemplateModules)
+	return moduleKey != ""
 }
 
-// Determines if a task uses the template module
-func IsTemplateTask(task map[string]interface{}) bool {
-	_, hasTemplate := task["template"]
-	_, hasBuiltinTemplate := task["ansible.builtin.template"]
-	return hasTemplate || hasBuiltinTemplate
+// Checks if the arguments of a template task can be read, as a parameter
+// mapping or free-form key=value pairs
+func HasTemplateArgs(task map[string]interface{}, opts RenderOptions) bool {
+	_, ok := NewTemplateTask(task, opts)
+	return ok
+}
+
+// Checks if copy module arguments produce a file that can be rendered locally.
+// Sources on the remote host (remote_src) are not available on the controller.
+func isRenderableCopy(module map[string]interface{}, opts RenderOptions) bool {
+	if _, ok := module["dest"].(string); !ok {
+		return false
+	}
+
+	if _, ok := module["content"]; ok {
+		return true
+	}
+
+	if !opts.CopySources {
+		return false
+	}
+	if _, ok := module["src"].(string); !ok {
+		return false
+	}
+	return !isTrue(module["remote_src"])
+}
+
+// Checks if a YAML value is a true boolean, including Ansible's string forms
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		switch strings.ToLower(v) {
+		case "yes", "true", "on", "1":
+			return true
+		}
+	}
+	return false
 }
 
 // Modifies a template task by:
 // - Adding render_config tag
-// - Setting delegate_to: localhost and run_once: true
+// - Setting delegate_to: localhost (and run_once: true unless rendering per host)
 // - Removing notify handlers (not needed for rendering)
-func ModifyTemplateTask(task map[string]interface{}, playbookName string) {
-	templateTask, isTemplate := NewTemplateTask(task)
+func ModifyTemplateTask(task map[string]interface{}, opts RenderOptions) {
+	templateTask, isTemplate := NewTemplateTask(task, opts)
 	if !isTemplate {
 		return
 	}
 
-	templateTask.Modify(playbookName)
+	templateTask.Modify(opts)
 }
 
-// Identifies the template module and its key
-func findTemplateModule(task map[string]interface{}) (string, map[string]interface{}) {
-	if module, ok := task["template"].(map[string]interface{}); ok {
-		return "template", module
+// Identifies the template module, its key and the key of its destination
+func findTemplateModule(task map[string]interface{}, opts RenderOptions) (string, map[string]interface{}, string) {
+	for _, key := range templateModuleKeys {
+		if module, ok := moduleArgs(task[key]); ok {
+			return key, module, "dest"
+		}
 	}
 
-	if module, ok := task["ansible.builtin.template"].(map[string]interface{}); ok {
-		return "ansible.builtin.template", module
+	for _, key := range copyModuleKeys {
+		if module, ok := moduleArgs(task[key]); ok && isRenderableCopy(module, opts) {
+			return key, module, "dest"
+		}
 	}
 
-	return "", nil
+	return findExtraTemplateModule(task, opts.TemplateModules)
 }
 
 // Checks if render_config tag exists in tags array
@@ -51,7 +51,7 @@ func TestIsTemplateTask(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := IsTemplateTask(tt.task)
+			result := IsTemplateTask(tt.task, RenderOptions{})
 			if result != tt.expected {
 				t.Errorf("IsTemplateTask() = %v, want %v", result, tt.expected)
 			}
@@ -68,7 +68,7 @@ func TestNewTemplateTask(t *testing.T) {
 		},
 	}
 
-	task, ok := NewTemplateTask(standardTask)
+	task, ok := NewTemplateTask(standardTask, RenderOptions{})
 	if !ok {
 		t.Fatalf("NewTemplateTask() failed to recognize template task")
 	}
@@ -87,7 +87,7 @@ func TestNewTemplateTask(t *testing.T) {
 		"command": "echo test",
 	}
 
-	_, ok = NewTemplateTask(nonTemplateTask)
+	_, ok = NewTemplateTask(nonTemplateTask, RenderOptions{})
 	if ok {
 		t.Errorf("NewTemplateTask() should return false for non-template task")
 	}
@@ -139,7 +139,7 @@ func TestTemplateTask_GetDestPath(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			templateTask, ok := NewTemplateTask(tt.task)
+			templateTask, ok := NewTemplateTask(tt.task, RenderOptions{})
 			if !ok {
 				if tt.expected != "" {
 					t.Fatalf("NewTemplateTask() failed to recognize template task")
@@ -166,13 +166,12 @@ func TestTemplateTask_Modify(t *testing.T) {
 		"notify": []interface{}{"restart app"},
 	}
 
-	templateTask, ok := NewTemplateTask(fullTask)
+	templateTask, ok := NewTemplateTask(fullTask, RenderOptions{})
 	if !ok {
 		t.Fatalf("NewTemplateTask() failed to recognize template task")
 	}
 
-	// Modify the task - Added "test-playbook" as playbookName argument
-	templateTask.Modify("test-playbook")
+	templateTask.Modify(RenderOptions{PlaybookName: "test-playbook"})
 
 	// Verify destination path has the prefix
 	module := fullTask["template"].(map[string]interface{})
@@ -206,6 +205,37 @@ func TestTemplateTask_Modify(t *testing.T) {
 	}
 }
 
+func TestTemplateTask_ModifyPerHost(t *testing.T) {
+	task := map[string]interface{}{
+		"template": map[string]interface{}{
+			"src":  "keepalived.conf.j2",
+			"dest": "/etc/keepalived/keepalived.conf",
+		},
+		"run_once": true,
+	}
+
+	templateTask, ok := NewTemplateTask(task, RenderOptions{})
+	if !ok {
+		t.Fatalf("NewTemplateTask() failed to recognize template task")
+	}
+
+	templateTask.Modify(RenderOptions{PlaybookName: "test-playbook", PerHost: true})
+
+	module := task["template"].(map[string]interface{})
+	expectedDest := "output/{{ inventory_hostname }}/etc/keepalived/keepalived.conf"
+	if module["dest"] != expectedDest {
+		t.Errorf("Destination not modified correctly: got %v, want %v", module["dest"], expectedDest)
+	}
+
+	if task["delegate_to"] != "localhost" {
+		t.Errorf("delegate_to not set correctly: %v", task["delegate_to"])
+	}
+
+	if _, hasRunOnce := task["run_once"]; hasRunOnce {
+		t.Errorf("run_once should be removed in per-host mode")
+	}
+}
+
 func TestModifyTemplateTask(t *testing.T) {
 	// Create a copy of the task to verify it's not modified when not a template
 	nonTemplateTask := map[string]interface{}{
@@ -216,8 +246,8 @@ func TestModifyTemplateTask(t *testing.T) {
 		nonTemplateCopy[k] = v
 	}
 
-	// Test with non-template task - Added "test-playbook" as playbookName argument
-	ModifyTemplateTask(nonTemplateTask, "test-playbook")
+	// Test with non-template task
+	ModifyTemplateTask(nonTemplateTask, RenderOptions{PlaybookName: "test-playbook"})
 	if !reflect.DeepEqual(nonTemplateTask, nonTemplateCopy) {
 		t.Errorf("ModifyTemplateTask() should not modify non-template tasks")
 	}
@@ -231,8 +261,7 @@ func TestModifyTemplateTask(t *testing.T) {
 		"notify": []interface{}{"restart app"},
 	}
 
-	// Modify the task - Added "test-playbook" as playbookName argument
-	ModifyTemplateTask(templateTask, "test-playbook")
+	ModifyTemplateTask(templateTask, RenderOptions{PlaybookName: "test-playbook"})
 
 	// Verify task was modified
 	module := templateTask["template"].(map[string]interface{})
@@ -283,3 +312,171 @@ func TestHasRenderConfigTag(t *testing.T) {
 		})
 	}
 }
+
+func TestRenderOptions_OutputPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     RenderOptions
+		expected string
+	}{
+		{
+			name:     "default output directory",
+			opts:     RenderOptions{},
+			expected: "output/etc/app.conf",
+		},
+		{
+			name:     "custom output directory",
+			opts:     RenderOptions{OutputDir: "/srv/rendered"},
+			expected: "/srv/rendered/etc/app.conf",
+		},
+		{
+			name:     "custom output directory per host",
+			opts:     RenderOptions{OutputDir: "/srv/rendered", PerHost: true},
+			expected: "/srv/rendered/{{ inventory_hostname }}/etc/app.conf",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.opts.OutputPath("/etc/app.conf")
+			if result != tt.expected {
+				t.Errorf("OutputPath() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsTemplateTask_Copy(t *testing.T) {
+	tests := []struct {
+		name        string
+		task        map[string]interface{}
+		copySources bool
+		expected    bool
+	}{
+		{
+			name: "copy with inline content",
+			task: map[string]interface{}{
+				"ansible.builtin.copy": map[string]interface{}{
+					"content": "listen {{ port }}\n",
+					"dest":    "/etc/app/listen.conf",
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "copy with src is not rendered by default",
+			task: map[string]interface{}{
+				"copy": map[string]interface{}{
+					"src":  "app.conf",
+					"dest": "/etc/app/app.conf",
+				},
+			},
+			expected: false,
+		},
+		{
+			name: "copy with src when copy sources are rendered",
+			task: map[string]interface{}{
+				"copy": map[string]interface{}{
+					"src":  "app.conf",
+					"dest": "/etc/app/app.conf",
+				},
+			},
+			copySources: true,
+			expected:    true,
+		},
+		{
+			name: "copy with remote src",
+			task: map[string]interface{}{
+				"copy": map[string]interface{}{
+					"src":        "/tmp/app.conf",
+					"dest":       "/etc/app/app.conf",
+					"remote_src": "yes",
+				},
+			},
+			copySources: true,
+			expected:    false,
+		},
+		{
+			name: "copy without dest",
+			task: map[string]interface{}{
+				"copy": map[string]interface{}{
+					"content": "data",
+				},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := RenderOptions{CopySources: tt.copySources}
+
+			result := IsTemplateTask(tt.task, opts)
+			if result != tt.expected {
+				t.Errorf("IsTemplateTask() = %v, want %v", result, tt.expected)
+			}
+
+			_, ok := NewTemplateTask(tt.task, opts)
+			if ok != tt.expected {
+				t.Errorf("NewTemplateTask() ok = %v, want %v", ok, tt.expected)
+			}
+		})
+	}
+}
+
+func TestModifyTemplateTask_CopyContent(t *testing.T) {
+	task := map[string]interface{}{
+		"copy": map[string]interface{}{
+			"content": "listen {{ port }}\n",
+			"dest":    "/etc/app/listen.conf",
+			"owner":   "root",
+			"mode":    "0600",
+		},
+		"notify": "restart app",
+	}
+
+	ModifyTemplateTask(task, RenderOptions{})
+
+	module := task["copy"].(map[string]interface{})
+	if module["dest"] != "output/etc/app/listen.conf" {
+		t.Errorf("Destination not modified correctly: got %v", module["dest"])
+	}
+	if module["content"] != "listen {{ port }}\n" {
+		t.Errorf("content should be kept: got %v", module["content"])
+	}
+	if _, hasOwner := module["owner"]; hasOwner {
+		t.Errorf("owner was not removed")
+	}
+	if task["delegate_to"] != "localhost" {
+		t.Errorf("delegate_to not set correctly: %v", task["delegate_to"])
+	}
+	if _, hasNotify := task["notify"]; hasNotify {
+		t.Errorf("notify was not removed")
+	}
+}
+
+func TestTemplateTask_ModifyValidate(t *testing.T) {
+	task := map[string]interface{}{
+		"template": map[string]interface{}{
+			"src":      "nginx.conf.j2",
+			"dest":     "/etc/nginx/nginx.conf",
+			"validate": "nginx -t -c %s",
+		},
+	}
+
+	templateTask, ok := NewTemplateTask(task, RenderOptions{})
+	if !ok {
+		t.Fatalf("NewTemplateTask() failed to recognize template task")
+	}
+
+	if got := templateTask.GetValidateCommand(); got != "nginx -t -c %s" {
+		t.Errorf("GetValidateCommand() = %q, want %q", got, "nginx -t -c %s")
+	}
+
+	templateTask.Modify(RenderOptions{})
+
+	module := task["template"].(map[string]interface{})
+	if _, hasValidate := module["validate"]; hasValidate {
+		t.Errorf("validate was not removed")
+	}
+}
@@ -0,0 +1,125 @@
+package compare
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Describes how a rendered tree differs from a reference tree
+type Result struct {
+	Added   []string // Files only in the rendered tree
+	Removed []string // Files only in the reference tree
+	Changed []string // Files in both trees with different content
+	Diff    string   // Unified diff of all differences
+}
+
+// Checks if the trees differ
+func (r *Result) HasDifferences() bool {
+	return len(r.Added) > 0 || len(r.Removed) > 0 || len(r.Changed) > 0
+}
+
+// Compares the files of a rendered tree against a reference tree. Paths are
+// slash-separated and relative to the tree roots; ignored paths are skipped
+// in both trees.
+func Trees(referenceDir, renderedDir string, ignore ...string) (*Result, error) {
+	referenceFiles, err := listFiles(referenceDir, ignore)
+	if err != nil {
+		return nil, fmt.Errorf("listing reference files: %w", err)
+	}
+
+	renderedFiles, err := listFiles(renderedDir, ignore)
+	if err != nil {
+		return nil, fmt.Errorf("listing rendered files: %w", err)
+	}
+
+	paths := make([]string, 0, len(referenceFiles)+len(renderedFiles))
+	for path := range referenceFiles {
+		paths = append(paths, path)
+	}
+	for path := range renderedFiles {
+		if !referenceFiles[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	result := &Result{}
+	var diff strings.Builder
+
+	for _, path := range paths {
+		fromName, toName := "a/"+path, "b/"+path
+
+		var from, to []byte
+		if referenceFiles[path] {
+			if from, err = os.ReadFile(filepath.Join(referenceDir, filepath.FromSlash(path))); err != nil {
+				return nil, fmt.Errorf("reading reference file: %w", err)
+			}
+		} else {
+			fromName = "/dev/null"
+		}
+		if renderedFiles[path] {
+			if to, err = os.ReadFile(filepath.Join(renderedDir, filepath.FromSlash(path))); err != nil {
+				return nil, fmt.Errorf("reading rendered file: %w", err)
+			}
+		} else {
+			toName = "/dev/null"
+		}
+
+		switch {
+		case !referenceFiles[path]:
+			result.Added = append(result.Added, path)
+		case !renderedFiles[path]:
+			result.Removed = append(result.Removed, path)
+		case !bytes.Equal(from, to):
+			result.Changed = append(result.Changed, path)
+		default:
+			continue
+		}
+
+		diff.WriteString(Unified(fromName, toName, from, to))
+	}
+
+	result.Diff = diff.String()
+	return result, nil
+}
+
+// Lists the regular files under a directory, following symlinks to files
+func listFiles(root string, ignore []string) (map[string]bool, error) {
+	ignored := make(map[string]bool, len(ignore))
+	for _, path := range ignore {
+		ignored[filepath.ToSlash(path)] = true
+	}
+
+	files := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil // Skip dangling symlinks and special files
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if !ignored[relPath] {
+			files[relPath] = true
+		}
+		return nil
+	})
+
+	return files, err
+}
@@ -0,0 +1,89 @@
+package compare
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Writes files, given by slash-separated path, under a directory
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		fullPath := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+}
+
+func TestTrees(t *testing.T) {
+	referenceDir := t.TempDir()
+	renderedDir := t.TempDir()
+
+	writeTree(t, referenceDir, map[string]string{
+		"etc/motd":             "hello\n",
+		"etc/nginx/nginx.conf": "worker_processes 2;\n",
+		"etc/old.conf":         "old\n",
+		"manifest.json":        "{}\n",
+	})
+	writeTree(t, renderedDir, map[string]string{
+		"etc/motd":             "hello\n",
+		"etc/nginx/nginx.conf": "worker_processes 4;\n",
+		"etc/new.conf":         "new\n",
+		"manifest.json":        "{\"files\": []}\n",
+	})
+
+	result, err := Trees(referenceDir, renderedDir, "manifest.json")
+	if err != nil {
+		t.Fatalf("Trees() error = %v", err)
+	}
+
+	if !result.HasDifferences() {
+		t.Fatalf("HasDifferences() = false, want true")
+	}
+	if !reflect.DeepEqual(result.Added, []string{"etc/new.conf"}) {
+		t.Errorf("Added = %v", result.Added)
+	}
+	if !reflect.DeepEqual(result.Removed, []string{"etc/old.conf"}) {
+		t.Errorf("Removed = %v", result.Removed)
+	}
+	if !reflect.DeepEqual(result.Changed, []string{"etc/nginx/nginx.conf"}) {
+		t.Errorf("Changed = %v", result.Changed)
+	}
+
+	for _, want := range []string{
+		"--- /dev/null\n+++ b/etc/new.conf\n@@ -0,0 +1 @@\n+new\n",
+		"--- a/etc/nginx/nginx.conf\n+++ b/etc/nginx/nginx.conf\n@@ -1 +1 @@\n-worker_processes 2;\n+worker_processes 4;\n",
+		"--- a/etc/old.conf\n+++ /dev/null\n@@ -1 +0,0 @@\n-old\n",
+	} {
+		if !strings.Contains(result.Diff, want) {
+			t.Errorf("Diff does not contain %q:\n%s", want, result.Diff)
+		}
+	}
+	if strings.Contains(result.Diff, "manifest.json") {
+		t.Errorf("Diff contains the ignored manifest:\n%s", result.Diff)
+	}
+}
+
+func TestTrees_Identical(t *testing.T) {
+	referenceDir := t.TempDir()
+	renderedDir := t.TempDir()
+
+	files := map[string]string{"etc/motd": "hello\n"}
+	writeTree(t, referenceDir, files)
+	writeTree(t, renderedDir, files)
+
+	result, err := Trees(referenceDir, renderedDir)
+	if err != nil {
+		t.Fatalf("Trees() error = %v", err)
+	}
+	if result.HasDifferences() || result.Diff != "" {
+		t.Errorf("Trees() = %+v, want no differences", result)
+	}
+}
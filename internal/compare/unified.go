@@ -0,0 +1,351 @@
+package compare
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Lines of unchanged context around each hunk
+const contextLines = 3
+
+// Kinds of line edits
+const (
+	editEqual = iota
+	editDelete
+	editInsert
+)
+
+// Represents a line kept, deleted from the old file or inserted from the new one
+type lineEdit struct {
+	kind int
+	aPos int // Position in the old file
+	bPos int // Position in the new file
+}
+
+// Returns a unified diff between two versions of a file, empty when they
+// are equal. Binary content is only reported as differing.
+func Unified(fromName, toName string, from, to []byte) string {
+	if bytes.Equal(from, to) {
+		return ""
+	}
+
+	if isBinary(from) || isBinary(to) {
+		return fmt.Sprintf("Binary files %s and %s differ\n", fromName, toName)
+	}
+
+	a, b := splitLines(from), splitLines(to)
+	edits := diffLines(a, b)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(edits); {
+		// Skip to the next change
+		for i < len(edits) && edits[i].kind == editEqual {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		start := i - contextLines
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk over changes separated by little enough context
+		end := i
+		for end < len(edits) {
+			if edits[end].kind != editEqual {
+				end++
+				continue
+			}
+
+			run := end
+			for run < len(edits) && edits[run].kind == editEqual {
+				run++
+			}
+			if run == len(edits) || run-end > 2*contextLines {
+				end += contextLines
+				if end > len(edits) {
+					end = len(edits)
+				}
+				break
+			}
+			end = run
+		}
+
+		writeHunk(&out, edits[start:end], a, b)
+		i = end
+	}
+
+	return out.String()
+}
+
+// Writes a hunk header and its lines
+func writeHunk(out *strings.Builder, hunk []lineEdit, a, b []string) {
+	aCount, bCount := 0, 0
+	for _, edit := range hunk {
+		if edit.kind != editInsert {
+			aCount++
+		}
+		if edit.kind != editDelete {
+			bCount++
+		}
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(hunk[0].aPos, aCount), hunkRange(hunk[0].bPos, bCount))
+
+	for _, edit := range hunk {
+		switch edit.kind {
+		case editEqual:
+			writeLine(out, " ", a[edit.aPos])
+		case editDelete:
+			writeLine(out, "-", a[edit.aPos])
+		case editInsert:
+			writeLine(out, "+", b[edit.bPos])
+		}
+	}
+}
+
+// Formats the line range of a hunk from its 0-based start
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// Writes a diff line, marking a last line without newline
+func writeLine(out *strings.Builder, prefix, line string) {
+	out.WriteString(prefix)
+	out.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		out.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// Splits content into lines, each keeping its newline
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Checks if content looks binary
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// Returns the shortest edit script turning a into b, using the linear-space
+// variant of Myers' algorithm: the middle snake of an optimal path splits
+// the files, and both halves are diffed recursively
+func diffLines(a, b []string) []lineEdit {
+	ids := make(map[string]int)
+	aIDs, bIDs := internLines(a, ids), internLines(b, ids)
+
+	// Lines found in only one file are changes either way; leaving them
+	// out of the search keeps it short when most lines changed
+	aKept, bKept := linesInBoth(aIDs, bIDs), linesInBoth(bIDs, aIDs)
+
+	d := &myersDiff{
+		a: selectLines(aIDs, aKept),
+		b: selectLines(bIDs, bKept),
+	}
+
+	// Diagonals range over -(n+m)..n+m, with one extra on either side
+	d.offset = len(d.a) + len(d.b) + 1
+	d.forward = make([]int, 2*d.offset+1)
+	d.backward = make([]int, 2*d.offset+1)
+
+	d.compare(0, len(d.a), 0, len(d.b))
+
+	// Lines between matched lines are deleted from a and inserted from b
+	var edits []lineEdit
+	x, y := 0, 0
+	emitChanges := func(aEnd, bEnd int) {
+		for ; x < aEnd; x++ {
+			edits = append(edits, lineEdit{kind: editDelete, aPos: x, bPos: y})
+		}
+		for ; y < bEnd; y++ {
+			edits = append(edits, lineEdit{kind: editInsert, aPos: x, bPos: y})
+		}
+	}
+
+	for _, edit := range d.edits {
+		if edit.kind != editEqual {
+			continue
+		}
+		emitChanges(aKept[edit.aPos], bKept[edit.bPos])
+		edits = append(edits, lineEdit{kind: editEqual, aPos: x, bPos: y})
+		x++
+		y++
+	}
+	emitChanges(len(a), len(b))
+
+	return edits
+}
+
+// Maps lines to integers, so comparisons do not compare strings
+func internLines(lines []string, ids map[string]int) []int {
+	result := make([]int, len(lines))
+	for i, line := range lines {
+		id, ok := ids[line]
+		if !ok {
+			id = len(ids)
+			ids[line] = id
+		}
+		result[i] = id
+	}
+	return result
+}
+
+// Returns the positions of the lines that also occur in other
+func linesInBoth(lines, other []int) []int {
+	present := make(map[int]bool, len(other))
+	for _, id := range other {
+		present[id] = true
+	}
+
+	var kept []int
+	for i, id := range lines {
+		if present[id] {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
+
+// Returns the lines at the given positions
+func selectLines(lines, positions []int) []int {
+	result := make([]int, len(positions))
+	for i, pos := range positions {
+		result[i] = lines[pos]
+	}
+	return result
+}
+
+// Holds the state of a linear-space diff
+type myersDiff struct {
+	a, b     []int
+	forward  []int // Furthest x on each diagonal, from the start of a range
+	backward []int // Furthest x on each diagonal, from the end of a range
+	offset   int   // Index of diagonal 0
+	edits    []lineEdit
+}
+
+// Appends the edits turning a[aLo:aHi] into b[bLo:bHi]
+func (d *myersDiff) compare(aLo, aHi, bLo, bHi int) {
+	// Common lines at either end are equal without searching
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.edits = append(d.edits, lineEdit{kind: editEqual, aPos: aLo, bPos: bLo})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+		suffix++
+	}
+
+	switch {
+	case aLo == aHi:
+		for y := bLo; y < bHi; y++ {
+			d.edits = append(d.edits, lineEdit{kind: editInsert, aPos: aLo, bPos: y})
+		}
+	case bLo == bHi:
+		for x := aLo; x < aHi; x++ {
+			d.edits = append(d.edits, lineEdit{kind: editDelete, aPos: x, bPos: bLo})
+		}
+	default:
+		// Both ranges differ at their first and last lines, so the middle
+		// snake lies strictly inside and each half is smaller
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.edits = append(d.edits, lineEdit{kind: editEqual, aPos: x, bPos: y})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.edits = append(d.edits, lineEdit{kind: editEqual, aPos: aHi + i, bPos: bHi + i})
+	}
+}
+
+// Finds the middle snake of an optimal path from (aLo, bLo) to (aHi, bHi),
+// searching from both ends until the paths overlap. Returns the start and
+// end of the snake.
+func (d *myersDiff) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+
+	d.forward[d.offset+1] = 0
+	d.backward[d.offset+1] = 0
+
+	for step := 0; step <= (n+m+1)/2; step++ {
+		// Forward paths, on diagonals k = x - y
+		for k := -step; k <= step; k += 2 {
+			i := d.offset + k
+			var x int
+			if k == -step || (k != step && d.forward[i-1] < d.forward[i+1]) {
+				x = d.forward[i+1]
+			} else {
+				x = d.forward[i-1] + 1
+			}
+			y := x - k
+
+			startX, startY := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			d.forward[i] = x
+
+			// Backward diagonal delta-k was reached after step-1 edits
+			if kb := delta - k; odd && kb >= -(step-1) && kb <= step-1 && x+d.backward[d.offset+kb] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y
+			}
+		}
+
+		// Backward paths, measured from the ends, on diagonals k = x - y
+		for k := -step; k <= step; k += 2 {
+			i := d.offset + k
+			var x int
+			if k == -step || (k != step && d.backward[i-1] < d.backward[i+1]) {
+				x = d.backward[i+1]
+			} else {
+				x = d.backward[i-1] + 1
+			}
+			y := x - k
+
+			startX, startY := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			d.backward[i] = x
+
+			// Forward diagonal delta-k was reached after step edits
+			if kf := delta - k; !odd && kf >= -step && kf <= step && x+d.forward[d.offset+kf] >= n {
+				return aHi - x, bHi - y, aHi - startX, bHi - startY
+			}
+		}
+	}
+
+	// Unreachable: the paths overlap after at most (n+m+1)/2 steps
+	return aLo, bLo, aLo, bLo
+}
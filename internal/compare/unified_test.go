@@ -0,0 +1,194 @@
+package compare
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{
+			name: "Equal",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "Changed line with context",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			to:   "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- a/f\n+++ b/f\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "Separate hunks",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			to:   "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			want: "--- a/f\n+++ b/f\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name: "New file",
+			from: "",
+			to:   "a\n",
+			want: "--- a/f\n+++ b/f\n@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name: "Missing final newline",
+			from: "a\n",
+			to:   "a",
+			want: "--- a/f\n+++ b/f\n@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+		{
+			name: "Binary",
+			from: "a\x00",
+			to:   "b\x00",
+			want: "Binary files a/f and b/f differ\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified("a/f", "b/f", []byte(tt.from), []byte(tt.to))
+			if got != tt.want {
+				t.Errorf("Unified() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnified_LargeInput(t *testing.T) {
+	const lines = 20000
+
+	// Every other line changes
+	var changedFrom, changedTo strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&changedFrom, "line %d\n", i)
+		if i%2 == 0 {
+			fmt.Fprintf(&changedTo, "changed %d\n", i)
+		} else {
+			fmt.Fprintf(&changedTo, "line %d\n", i)
+		}
+	}
+
+	// The two halves swap places, so every line occurs in both files
+	var swappedFrom, swappedTo strings.Builder
+	for i := 0; i < lines/2; i++ {
+		fmt.Fprintf(&swappedFrom, "line %d\n", i)
+		fmt.Fprintf(&swappedTo, "line %d\n", (i+lines/4)%(lines/2))
+	}
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		changes int // Lines removed, and lines added
+	}{
+		{"Every other line changed", changedFrom.String(), changedTo.String(), lines / 2},
+		{"Halves swapped", swappedFrom.String(), swappedTo.String(), lines / 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before runtime.MemStats
+			runtime.ReadMemStats(&before)
+
+			diff := Unified("a/f", "b/f", []byte(tt.from), []byte(tt.to))
+
+			var after runtime.MemStats
+			runtime.ReadMemStats(&after)
+
+			// The search must not keep a trace per edit
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+				t.Errorf("Unified() allocated %d MB, want at most 64 MB", allocated>>20)
+			}
+
+			removed, added := 0, 0
+			for _, line := range strings.Split(diff, "\n") {
+				switch {
+				case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+				case strings.HasPrefix(line, "-"):
+					removed++
+				case strings.HasPrefix(line, "+"):
+					added++
+				}
+			}
+			if removed != tt.changes || added != tt.changes {
+				t.Errorf("Unified() removed %d and added %d lines, want %d each", removed, added, tt.changes)
+			}
+		})
+	}
+}
+
+func TestDiffLines_ShortestEditScript(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(40))
+		for i := range lines {
+			lines[i] = string(rune('a'+random.Intn(5))) + "\n"
+		}
+		return lines
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(), randomLines()
+		edits := diffLines(a, b)
+
+		// Applying the edits turns a into b
+		x, y, changes := 0, 0, 0
+		for _, edit := range edits {
+			if edit.aPos != x || edit.bPos != y {
+				t.Fatalf("diffLines(%q, %q) edit at %d,%d, want %d,%d", a, b, edit.aPos, edit.bPos, x, y)
+			}
+			switch edit.kind {
+			case editEqual:
+				if a[x] != b[y] {
+					t.Fatalf("diffLines(%q, %q) keeps differing lines %d and %d", a, b, x, y)
+				}
+				x++
+				y++
+			case editDelete:
+				x++
+				changes++
+			case editInsert:
+				y++
+				changes++
+			}
+		}
+		if x != len(a) || y != len(b) {
+			t.Fatalf("diffLines(%q, %q) stops at %d,%d", a, b, x, y)
+		}
+
+		if want := len(a) + len(b) - 2*longestCommonSubsequence(a, b); changes != want {
+			t.Fatalf("diffLines(%q, %q) has %d changes, want %d", a, b, changes, want)
+		}
+	}
+}
+
+// Returns the length of the longest common subsequence, by dynamic programming
+func longestCommonSubsequence(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] > lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
@@ -4,16 +4,23 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/zinrai/ansible-template-render/internal/logger"
 	"github.com/zinrai/ansible-template-render/internal/utils"
 )
 
 // Handles copying playbook files
 type PlaybookCopier struct{}
 
-// Copies a playbook file to the destination directory
-func (c *PlaybookCopier) CopyPlaybook(playbookPath, destDir string) (string, error) {
-	destPlaybookPath := filepath.Join(destDir, filepath.Base(playbookPath))
+// Copies a playbook file to the destination directory,
+// preserving its path relative to rootDir
+func (c *PlaybookCopier) CopyPlaybookRelative(playbookPath, rootDir, destDir string) (string, error) {
+	relPath, err := filepath.Rel(rootDir, playbookPath)
+	if err != nil {
+		return "", fmt.Errorf("getting relative playbook path: %w", err)
+	}
+	destPlaybookPath := filepath.Join(destDir, relPath)
 
 	// Create destination directory if needed
 	destDirPath := filepath.Dir(destPlaybookPath)
@@ -28,3 +35,76 @@ func (c *PlaybookCopier) CopyPlaybook(playbookPath, destDir string) (string, err
 
 	return destPlaybookPath, nil
 }
+
+// Directories next to a playbook that play tasks read from
+var playbookResourceDirs = []string{"templates", "files"}
+
+// Copies the templates and files directories next to a playbook,
+// preserving their path relative to rootDir
+func (c *PlaybookCopier) CopyPlaybookResources(playbookPath, rootDir, destDir string) error {
+	playbookDir := filepath.Dir(playbookPath)
+
+	for _, dirName := range playbookResourceDirs {
+		srcDir := filepath.Join(playbookDir, dirName)
+		info, err := os.Stat(srcDir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		relPath, err := filepath.Rel(rootDir, srcDir)
+		if err != nil {
+			return fmt.Errorf("getting relative path: %w", err)
+		}
+
+		if err := copyDir(srcDir, filepath.Join(destDir, relPath)); err != nil {
+			return fmt.Errorf("copying %s directory: %w", dirName, err)
+		}
+	}
+
+	return nil
+}
+
+// Copies the vars_files of a playbook's plays, preserving their path
+// relative to rootDir. Missing files are skipped: an entry may list
+// alternatives of which only some exist.
+func (c *PlaybookCopier) CopyPlaybookVarsFiles(playbookPath string, varsFiles []string, rootDir, destDir string) error {
+	playbookDir := filepath.Dir(playbookPath)
+
+	for _, varsFile := range varsFiles {
+		if varsFile == "" || strings.Contains(varsFile, "{{") {
+			logger.Warn("Skipping templated vars file", "playbook", playbookPath, "file", varsFile)
+			continue
+		}
+
+		srcPath := varsFile
+		if !filepath.IsAbs(srcPath) {
+			srcPath = filepath.Join(playbookDir, srcPath)
+		}
+		srcPath = filepath.Clean(srcPath)
+
+		info, err := os.Stat(srcPath)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		if !utils.IsWithinDir(srcPath, rootDir) {
+			logger.Warn("Skipping vars file outside the playbook tree", "playbook", playbookPath, "file", srcPath)
+			continue
+		}
+
+		relPath, err := filepath.Rel(rootDir, srcPath)
+		if err != nil {
+			return fmt.Errorf("getting relative path: %w", err)
+		}
+
+		destPath := filepath.Join(destDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return fmt.Errorf("creating vars file directory: %w", err)
+		}
+		if err := utils.CopyFile(srcPath, destPath); err != nil {
+			return fmt.Errorf("copying vars file %s: %w", varsFile, err)
+		}
+	}
+
+	return nil
+}
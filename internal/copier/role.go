@@ -5,23 +5,29 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/zinrai/ansible-template-render/internal/finder"
 	"github.com/zinrai/ansible-template-render/internal/logger"
 	"github.com/zinrai/ansible-template-render/internal/utils"
 )
 
 // Handles copying role structures
-type RoleCopier struct{}
+type RoleCopier struct {
+	Paths finder.SearchPaths // Where roles and collections are looked up
+}
 
 // Copies a role's directory structure to the destination directory
 func (c *RoleCopier) CopyRole(roleName string, destDir string) error {
-	srcRolePath := filepath.Join("roles", roleName)
-	destRolePath := filepath.Join(destDir, "roles", roleName)
+	if role, ok := finder.ParseCollectionRole(roleName); ok {
+		if _, collectionPath, found := c.Paths.FindCollectionRolePath(role); found {
+			return c.copyCollection(collectionPath, role, destDir)
+		}
+	}
 
-	// Check if source role directory exists
-	_, err := os.Stat(srcRolePath)
-	if os.IsNotExist(err) {
-		return fmt.Errorf("role directory not found: %s", srcRolePath)
+	srcRolePath, err := c.Paths.FindRolePath(roleName)
+	if err != nil {
+		return err
 	}
+	destRolePath := filepath.Join(destDir, "roles", roleName)
 
 	// Create destination role directory
 	if err := os.MkdirAll(destRolePath, 0755); err != nil {
@@ -32,6 +38,25 @@ func (c *RoleCopier) CopyRole(roleName string, destDir string) error {
 	return c.copyRoleContents(srcRolePath, destRolePath)
 }
 
+// Copies the whole collection providing a role, so plugins used by its
+// templates are available too
+func (c *RoleCopier) copyCollection(collectionPath string, role finder.CollectionRole, destDir string) error {
+	destCollectionPath := filepath.Join(destDir, "collections", role.CollectionRelPath())
+
+	// Another role from the same collection was already copied
+	if _, err := os.Stat(destCollectionPath); err == nil {
+		return nil
+	}
+
+	logger.Info("Copying collection", "name", role.CollectionName(), "from", collectionPath)
+
+	if err := os.MkdirAll(destCollectionPath, 0755); err != nil {
+		return fmt.Errorf("creating collection directory: %w", err)
+	}
+
+	return c.copyRoleContents(collectionPath, destCollectionPath)
+}
+
 // Recursively copies the role contents
 func (c *RoleCopier) copyRoleContents(srcPath, destPath string) error {
 	// Check if directory exists (follow symlinks)
@@ -137,8 +162,8 @@ func (c *RoleCopier) copyFileWithLogging(srcPath, destPath string) error {
 }
 
 // Copies all specified roles to the destination directory
-func CopyAllRoles(roles []string, destDir string) error {
-	copier := &RoleCopier{}
+func CopyAllRoles(roles []string, destDir string, paths finder.SearchPaths) error {
+	copier := &RoleCopier{Paths: paths}
 
 	for _, roleName := range roles {
 		logger.Info("Copying role", "name", roleName)
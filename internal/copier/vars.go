@@ -4,33 +4,119 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/zinrai/ansible-template-render/internal/finder"
 	"github.com/zinrai/ansible-template-render/internal/logger"
 	"github.com/zinrai/ansible-template-render/internal/utils"
 )
 
-// Copies vars directories to the temporary directory
-func CopyVarsDirectories(varsDirectories finder.VarsDirectories, destDir string) error {
-	if varsDirectories.GroupVars != "" {
+// Copies vars directories next to the generated inventory and playbook, so
+// that Ansible loads them with the same precedence as the originals
+func CopyVarsDirectories(locations finder.VarsLocations, inventoryDestDir, playbookDestDir string) error {
+	if err := copyInventoryVars(locations.Inventory, inventoryDestDir); err != nil {
+		return err
+	}
+
+	return copyVars(locations.Playbook, playbookDestDir)
+}
+
+// Copies the group_vars and host_vars directories of one location
+func copyVars(dirs finder.VarsDirectories, destDir string) error {
+	if dirs.GroupVars != "" {
 		destGroupVars := filepath.Join(destDir, "group_vars")
-		if err := copyDir(varsDirectories.GroupVars, destGroupVars); err != nil {
+		if err := copyDir(dirs.GroupVars, destGroupVars); err != nil {
 			return fmt.Errorf("copying group_vars directory: %w", err)
 		}
-		logger.Info("Copied group_vars directory", "from", varsDirectories.GroupVars, "to", destGroupVars)
+		logger.Info("Copied group_vars directory", "from", dirs.GroupVars, "to", destGroupVars)
 	}
 
-	if varsDirectories.HostVars != "" {
+	if dirs.HostVars != "" {
 		destHostVars := filepath.Join(destDir, "host_vars")
-		if err := copyDir(varsDirectories.HostVars, destHostVars); err != nil {
+		if err := copyDir(dirs.HostVars, destHostVars); err != nil {
 			return fmt.Errorf("copying host_vars directory: %w", err)
 		}
-		logger.Info("Copied host_vars directory", "from", varsDirectories.HostVars, "to", destHostVars)
+		logger.Info("Copied host_vars directory", "from", dirs.HostVars, "to", destHostVars)
+	}
+
+	return nil
+}
+
+// Copies the vars directories of all inventory sources next to the single
+// generated inventory. Several sources are merged into one directory per
+// group or host, holding a numbered subdirectory per source: Ansible loads
+// them in order, so later sources still win.
+func copyInventoryVars(locations []finder.VarsDirectories, destDir string) error {
+	if len(locations) == 1 {
+		return copyVars(locations[0], destDir)
+	}
+
+	for i, dirs := range locations {
+		source := fmt.Sprintf("%02d", i)
+
+		if dirs.GroupVars != "" {
+			if err := mergeVarsDir(dirs.GroupVars, filepath.Join(destDir, "group_vars"), source); err != nil {
+				return fmt.Errorf("merging group_vars directory: %w", err)
+			}
+			logger.Info("Merged group_vars directory", "from", dirs.GroupVars, "to", filepath.Join(destDir, "group_vars"))
+		}
+
+		if dirs.HostVars != "" {
+			if err := mergeVarsDir(dirs.HostVars, filepath.Join(destDir, "host_vars"), source); err != nil {
+				return fmt.Errorf("merging host_vars directory: %w", err)
+			}
+			logger.Info("Merged host_vars directory", "from", dirs.HostVars, "to", filepath.Join(destDir, "host_vars"))
+		}
+	}
+
+	return nil
+}
+
+// Extensions of vars files, which name the group or host without them
+var varsFileExtensions = []string{".yml", ".yaml", ".json"}
+
+// Copies the vars files and directories of a vars directory into
+// <dst>/<group or host>/<source>/
+func mergeVarsDir(src, dst, source string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return fmt.Errorf("reading directory %s: %w", src, err)
+	}
+
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+
+		if entry.IsDir() {
+			if err := copyDir(srcPath, filepath.Join(dst, entry.Name(), source)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dstPath := filepath.Join(dst, varsName(entry.Name()), source, entry.Name())
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return fmt.Errorf("creating directory: %w", err)
+		}
+		if err := utils.CopyFile(srcPath, dstPath); err != nil {
+			return fmt.Errorf("copying file %s: %w", srcPath, err)
+		}
 	}
 
 	return nil
 }
 
+// Returns the group or host a vars file is for. Only the last extension is
+// dropped, as Ansible does: web.json.yml holds the vars of web.json.
+func varsName(fileName string) string {
+	ext := filepath.Ext(fileName)
+	for _, varsExt := range varsFileExtensions {
+		if ext == varsExt {
+			return strings.TrimSuffix(fileName, ext)
+		}
+	}
+	return fileName
+}
+
 // Recursively copies a directory
 func copyDir(src, dst string) error {
 	// Create destination directory
@@ -63,3 +149,8 @@ func copyDir(src, dst string) error {
 
 	return nil
 }
+
+// Copies a directory tree
+func CopyTree(src, dst string) error {
+	return copyDir(src, dst)
+}
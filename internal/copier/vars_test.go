@@ -0,0 +1,151 @@
+package copier
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/zinrai/ansible-template-render/internal/finder"
+)
+
+// Writes files under a directory, creating parent directories as needed
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		fullPath := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+}
+
+// Returns the files under a directory as slash-separated relative paths
+// mapped to their contents
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relPath)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", dir, err)
+	}
+	return files
+}
+
+func TestCopyVarsDirectories(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []map[string]string // Files of each inventory source directory
+		want    map[string]string   // Files expected next to the generated inventory
+	}{
+		{
+			name: "one source is copied as is",
+			sources: []map[string]string{{
+				"group_vars/web.yml":   "port: 80\n",
+				"host_vars/web1.yml":   "port: 8080\n",
+				"group_vars/all/a.yml": "env: prod\n",
+			}},
+			want: map[string]string{
+				"group_vars/web.yml":   "port: 80\n",
+				"host_vars/web1.yml":   "port: 8080\n",
+				"group_vars/all/a.yml": "env: prod\n",
+			},
+		},
+		{
+			name: "overlapping sources are merged in source order",
+			sources: []map[string]string{
+				{
+					"group_vars/web.yml": "port: 80\n",
+					"host_vars/web1.yml": "port: 8080\n",
+				},
+				{
+					"group_vars/web.yaml": "port: 81\n",
+					"group_vars/db.json":  "{\"port\": 5432}\n",
+				},
+			},
+			want: map[string]string{
+				"group_vars/web/00/web.yml":  "port: 80\n",
+				"group_vars/web/01/web.yaml": "port: 81\n",
+				"group_vars/db/01/db.json":   "{\"port\": 5432}\n",
+				"host_vars/web1/00/web1.yml": "port: 8080\n",
+			},
+		},
+		{
+			name: "directory entries and chained extensions",
+			sources: []map[string]string{
+				{
+					"group_vars/web/main.yml":  "port: 80\n",
+					"group_vars/web/extra.yml": "workers: 4\n",
+				},
+				{
+					"group_vars/web/main.yml": "port: 81\n",
+					"group_vars/web.json.yml": "dotted: true\n",
+					"group_vars/web.conf":     "raw: true\n",
+				},
+			},
+			want: map[string]string{
+				"group_vars/web/00/main.yml":          "port: 80\n",
+				"group_vars/web/00/extra.yml":         "workers: 4\n",
+				"group_vars/web/01/main.yml":          "port: 81\n",
+				"group_vars/web.json/01/web.json.yml": "dotted: true\n",
+				"group_vars/web.conf/01/web.conf":     "raw: true\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var locations finder.VarsLocations
+			for _, files := range tt.sources {
+				sourceDir := t.TempDir()
+				writeFiles(t, sourceDir, files)
+
+				var dirs finder.VarsDirectories
+				if info, err := os.Stat(filepath.Join(sourceDir, "group_vars")); err == nil && info.IsDir() {
+					dirs.GroupVars = filepath.Join(sourceDir, "group_vars")
+				}
+				if info, err := os.Stat(filepath.Join(sourceDir, "host_vars")); err == nil && info.IsDir() {
+					dirs.HostVars = filepath.Join(sourceDir, "host_vars")
+				}
+				locations.Inventory = append(locations.Inventory, dirs)
+			}
+
+			inventoryDir := t.TempDir()
+			if err := CopyVarsDirectories(locations, inventoryDir, t.TempDir()); err != nil {
+				t.Fatalf("CopyVarsDirectories() error = %v", err)
+			}
+
+			got := readFiles(t, inventoryDir)
+			for path, content := range tt.want {
+				if got[path] != content {
+					t.Errorf("%s = %q, want %q", path, got[path], content)
+				}
+			}
+			if len(got) != len(tt.want) {
+				var paths []string
+				for path := range got {
+					paths = append(paths, path)
+				}
+				sort.Strings(paths)
+				t.Errorf("copied files = %v, want %d files", paths, len(tt.want))
+			}
+		})
+	}
+}
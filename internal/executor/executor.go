@@ -11,11 +11,13 @@ import (
 
 // Represents the environment for Ansible execution
 type ExecutionEnvironment struct {
-	WorkingDir        string // Working directory
-	PlaybookPath      string // Path to the playbook (relative path)
-	InventoryPath     string // Path to the inventory (relative path)
-	AnsibleConfigPath string // Path to the ansible.cfg file (absolute path recommended)
-	AnsibleArgs       string // Additional arguments for ansible-playbook
+	WorkingDir        string   // Working directory
+	PlaybookPath      string   // Path to the playbook (relative path)
+	InventoryPaths    []string // Inventory sources (relative to the working directory or absolute)
+	ExtraVars         []string // Variables passed with -e before the additional arguments
+	Limit             string   // Host pattern passed with --limit, none when empty
+	AnsibleConfigPath string   // Path to the ansible.cfg file (absolute path recommended)
+	AnsibleArgs       []string // Additional arguments for ansible-playbook
 }
 
 // Executes an Ansible playbook
@@ -32,51 +34,67 @@ func RunAnsible(env ExecutionEnvironment) error {
 	}
 	defer os.Chdir(originalDir)
 
-	// Set the ANSIBLE_CONFIG environment variable
+	// Create command
+	args := PlaybookArgs(env)
+	cmd := exec.Command("ansible-playbook", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Set ANSIBLE_CONFIG for this run only, so later runs in the same
+	// process do not see a removed workspace
 	if env.AnsibleConfigPath != "" {
-		os.Setenv("ANSIBLE_CONFIG", env.AnsibleConfigPath)
+		cmd.Env = append(os.Environ(), "ANSIBLE_CONFIG="+env.AnsibleConfigPath)
 	}
 
-	// Build command line arguments
+	logger.Info("Executing Ansible command", "command", "ansible-playbook "+ShellJoin(args))
+
+	return cmd.Run()
+}
+
+// Returns the ansible-playbook arguments running the render_config tasks
+func PlaybookArgs(env ExecutionEnvironment) []string {
 	args := []string{
 		env.PlaybookPath,
 		"--tags", "render_config",
 	}
 
-	// Add inventory path if specified
-	if env.InventoryPath != "" {
-		args = append(args, "-i", env.InventoryPath)
+	// Add inventory sources and extra vars if specified
+	for _, inventoryPath := range env.InventoryPaths {
+		args = append(args, "-i", inventoryPath)
 	}
-
-	// Add additional ansible arguments if specified
-	if env.AnsibleArgs != "" {
-		extraArgs := parseAnsibleArgs(env.AnsibleArgs)
-		args = append(args, extraArgs...)
+	for _, extraVar := range env.ExtraVars {
+		args = append(args, "-e", extraVar)
+	}
+	if env.Limit != "" {
+		args = append(args, "--limit", env.Limit)
 	}
 
-	// Create command
-	cmd := exec.Command("ansible-playbook", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Additional arguments are passed as given, without splitting
+	return append(args, env.AnsibleArgs...)
+}
 
-	// Command string for logging
-	cmdString := fmt.Sprintf("ansible-playbook %s --tags render_config", env.PlaybookPath)
-	if env.InventoryPath != "" {
-		cmdString += fmt.Sprintf(" -i %s", env.InventoryPath)
+// Joins arguments into a shell command line, quoting those that need it
+func ShellJoin(args []string) string {
+	words := make([]string, 0, len(args))
+	for _, arg := range args {
+		words = append(words, ShellWord(arg))
 	}
-	if env.AnsibleArgs != "" {
-		cmdString += fmt.Sprintf(" %s", env.AnsibleArgs)
-	}
-	logger.Info("Executing Ansible command", "command", cmdString)
-
-	return cmd.Run()
+	return strings.Join(words, " ")
 }
 
-// Parses ansible arguments string into a slice of arguments
-func parseAnsibleArgs(argsStr string) []string {
-	argsStr = strings.TrimSpace(argsStr)
-	if argsStr == "" {
-		return nil
+// Returns an argument as a shell word, quoted unless it only holds
+// characters the shell takes literally
+func ShellWord(s string) string {
+	if s == "" {
+		return "''"
 	}
-	return strings.Fields(argsStr)
+	for _, r := range s {
+		if !strings.ContainsRune(shellSafeChars, r) {
+			return shellQuote(s)
+		}
+	}
+	return s
 }
+
+// Characters that never need quoting in a shell word
+const shellSafeChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./-_"
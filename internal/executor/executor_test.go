@@ -0,0 +1,105 @@
+package executor
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShellJoin_RoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"safe words", []string{"site.yml", "--tags", "render_config", "-i", "inventory/hosts.yml"}},
+		{"extra var with a space", []string{"-e", "greeting=hello world"}},
+		{"JSON extra var", []string{"-e", `{"a": [1, 2]}`}},
+		{"single quotes", []string{"-e", "msg='it''s'"}},
+		{"shell characters", []string{"$HOME", "`id`", "a;b", "*.yml", "a\\b", "!x"}},
+		{"empty argument", []string{"", "x"}},
+		{"newline", []string{"line1\nline2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := `printf '%s\0' ` + ShellJoin(tt.args)
+			out, err := exec.Command("sh", "-c", script).Output()
+			if err != nil {
+				t.Fatalf("sh -c %q error = %v", script, err)
+			}
+
+			got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+			if !reflect.DeepEqual(got, tt.args) {
+				t.Errorf("sh split %q into %q, want %q", ShellJoin(tt.args), got, tt.args)
+			}
+		})
+	}
+}
+
+func TestShellWord(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"site.yml", "site.yml"},
+		{"/tmp/render-1/ansible.cfg", "/tmp/render-1/ansible.cfg"},
+		{"", "''"},
+		{"/tmp/my dir", "'/tmp/my dir'"},
+		{"it's", `'it'\''s'`},
+	}
+
+	for _, tt := range tests {
+		if got := ShellWord(tt.input); got != tt.want {
+			t.Errorf("ShellWord(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPlaybookArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		env  ExecutionEnvironment
+		want []string
+	}{
+		{
+			name: "playbook only",
+			env:  ExecutionEnvironment{PlaybookPath: "site.yml"},
+			want: []string{"site.yml", "--tags", "render_config"},
+		},
+		{
+			name: "inventory, extra vars and limit",
+			env: ExecutionEnvironment{
+				PlaybookPath:   "site.yml",
+				InventoryPaths: []string{"inventory/a.yml", "inventory/b.ini"},
+				ExtraVars:      []string{"@vars.yml"},
+				Limit:          "webservers:!canary",
+			},
+			want: []string{"site.yml", "--tags", "render_config",
+				"-i", "inventory/a.yml", "-i", "inventory/b.ini",
+				"-e", "@vars.yml", "--limit", "webservers:!canary"},
+		},
+		{
+			name: "additional arguments are appended without splitting",
+			env: ExecutionEnvironment{
+				PlaybookPath: "site.yml",
+				ExtraVars:    []string{"@vars.yml"},
+				AnsibleArgs:  []string{"-e", "greeting=hello world", "-e", `{"a": [1, 2]}`, "--diff"},
+			},
+			want: []string{"site.yml", "--tags", "render_config", "-e", "@vars.yml",
+				"-e", "greeting=hello world", "-e", `{"a": [1, 2]}`, "--diff"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PlaybookArgs(tt.env); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlaybookArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/zinrai/ansible-template-render/internal/logger"
+)
+
+// Returns the top-level directory of the git repository containing dir, and
+// the path of dir inside it ("" at the top, otherwise ending in a slash)
+func GitTopLevel(dir string) (string, string, error) {
+	topLevel, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix, err := runGit(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", "", err
+	}
+
+	return topLevel, prefix, nil
+}
+
+// Resolves a revision to the commit it names
+func ResolveRevision(repoDir, revision string) (string, error) {
+	return runGit(repoDir, "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+}
+
+// Checks out a commit into a new detached worktree at path
+func AddWorktree(repoDir, path, commit string) error {
+	logger.Info("Adding worktree", "path", path, "commit", commit)
+	_, err := runGit(repoDir, "worktree", "add", "--detach", path, commit)
+	return err
+}
+
+// Removes a worktree added with AddWorktree
+func RemoveWorktree(repoDir, path string) error {
+	_, err := runGit(repoDir, "worktree", "remove", "--force", path)
+	return err
+}
+
+// Runs git in a directory and returns its trimmed output
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), message)
+		}
+		return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/zinrai/ansible-template-render/internal/logger"
+)
+
+// Represents a rendered file and the validate command of the task that rendered it
+type Validation struct {
+	Path    string // Rendered file
+	Command string // validate command from the task, with %s standing for the file
+}
+
+// Represents the outcome of validating a rendered file
+type ValidationResult struct {
+	Path    string
+	Command string // Command that was run, empty when skipped
+	Status  string // "passed", "failed" or "skipped"
+	Output  string
+}
+
+// Validation statuses
+const (
+	ValidationPassed  = "passed"
+	ValidationFailed  = "failed"
+	ValidationSkipped = "skipped"
+)
+
+// Returns the name a validate command is mapped by: the base name of its executable
+func ValidatorName(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ""
+	}
+	return filepath.Base(fields[0])
+}
+
+// Returns the local command for a validate command: the mapped command when
+// one is configured for its executable, otherwise the command itself
+func LocalValidateCommand(command string, validators map[string]string) string {
+	if local, ok := validators[ValidatorName(command)]; ok {
+		return local
+	}
+	return command
+}
+
+// Runs the validations against the rendered files, reporting each result
+func RunValidations(validations []Validation, validators map[string]string) []ValidationResult {
+	results := make([]ValidationResult, 0, len(validations))
+
+	for _, validation := range validations {
+		result := runValidation(validation, validators)
+		results = append(results, result)
+
+		switch result.Status {
+		case ValidationPassed:
+			logger.Info("Validation passed", "file", result.Path, "command", result.Command)
+		case ValidationFailed:
+			logger.Error("Validation failed", "file", result.Path, "command", result.Command, "output", result.Output)
+		default:
+			logger.Warn("Validation skipped", "file", result.Path, "reason", result.Output)
+		}
+	}
+
+	return results
+}
+
+// Runs a single validation
+func runValidation(validation Validation, validators map[string]string) ValidationResult {
+	result := ValidationResult{Path: validation.Path}
+
+	command := LocalValidateCommand(validation.Command, validators)
+	if !strings.Contains(command, "%s") {
+		result.Status = ValidationSkipped
+		result.Output = fmt.Sprintf("command %q has no %%s placeholder", command)
+		return result
+	}
+
+	// Commands that are not mapped run only when their executable is installed
+	if _, mapped := validators[ValidatorName(validation.Command)]; !mapped {
+		if _, err := exec.LookPath(strings.Fields(command)[0]); err != nil {
+			result.Status = ValidationSkipped
+			result.Output = fmt.Sprintf("%s is not installed and no validator is configured", ValidatorName(command))
+			return result
+		}
+	}
+
+	result.Command = strings.ReplaceAll(command, "%s", shellQuote(validation.Path))
+
+	output, err := exec.Command("sh", "-c", result.Command).CombinedOutput()
+	result.Output = strings.TrimSpace(string(output))
+	if err != nil {
+		result.Status = ValidationFailed
+		if result.Output == "" {
+			result.Output = err.Error()
+		}
+		return result
+	}
+
+	result.Status = ValidationPassed
+	return result
+}
+
+// Quotes a string for use as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,120 @@
+package finder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Default collections_path used by Ansible when none is configured
+var defaultCollectionsPath = []string{"~/.ansible/collections", "/usr/share/ansible/collections"}
+
+// Returns the collection search paths in Ansible's order: the playbook-adjacent
+// collections directories, then collections_path (ANSIBLE_COLLECTIONS_PATH or ansible.cfg)
+func DiscoverCollectionSearchPaths(playbookDirs []string) []string {
+	var paths []string
+	for _, dir := range playbookDirs {
+		paths = append(paths, filepath.Join(dir, "collections"))
+	}
+
+	configured := configuredPathList("ANSIBLE_COLLECTIONS_PATH", "collections_path", nil)
+	if configured == nil {
+		// Older Ansible releases use the plural names
+		configured = configuredPathList("ANSIBLE_COLLECTIONS_PATHS", "collections_paths", expandAll(defaultCollectionsPath))
+	}
+	paths = append(paths, configured...)
+
+	return removeDuplicatePaths(paths)
+}
+
+// Represents a role inside a collection
+type CollectionRole struct {
+	Namespace  string
+	Collection string
+	Role       string
+}
+
+// Parses a fully qualified collection role name (namespace.collection.role)
+func ParseCollectionRole(roleName string) (CollectionRole, bool) {
+	if strings.Contains(roleName, "/") {
+		return CollectionRole{}, false
+	}
+
+	parts := strings.Split(roleName, ".")
+	if len(parts) != 3 {
+		return CollectionRole{}, false
+	}
+
+	for _, part := range parts {
+		if part == "" {
+			return CollectionRole{}, false
+		}
+	}
+
+	return CollectionRole{Namespace: parts[0], Collection: parts[1], Role: parts[2]}, true
+}
+
+// Returns the collection name (namespace.collection)
+func (c CollectionRole) CollectionName() string {
+	return c.Namespace + "." + c.Collection
+}
+
+// Returns the collection directory relative to a collections path
+func (c CollectionRole) CollectionRelPath() string {
+	return filepath.Join("ansible_collections", c.Namespace, c.Collection)
+}
+
+// Finds a role inside the installed collections
+func (p SearchPaths) FindCollectionRolePath(role CollectionRole) (string, string, bool) {
+	for _, searchPath := range p.Collections {
+		// A search path may point at the ansible_collections directory itself
+		base := searchPath
+		if filepath.Base(base) == "ansible_collections" {
+			base = filepath.Dir(base)
+		}
+
+		collectionPath := filepath.Join(base, role.CollectionRelPath())
+		rolePath := filepath.Join(collectionPath, "roles", role.Role)
+
+		info, err := os.Stat(rolePath)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		return rolePath, collectionPath, true
+	}
+
+	return "", "", false
+}
+
+// Qualifies a short role name with the first listed collection that provides it,
+// following Ansible's lookup of the play-level collections keyword
+func (p SearchPaths) QualifyRoleName(roleName string, collections []string) string {
+	if _, ok := ParseCollectionRole(roleName); ok {
+		return roleName
+	}
+
+	for _, collection := range collections {
+		role, ok := ParseCollectionRole(collection + "." + roleName)
+		if !ok {
+			continue
+		}
+
+		if _, _, found := p.FindCollectionRolePath(role); found {
+			return collection + "." + roleName
+		}
+	}
+
+	return roleName
+}
+
+// Returns the path of a role inside the temporary directory
+func (p SearchPaths) RoleTempRelPath(roleName string) string {
+	if role, ok := ParseCollectionRole(roleName); ok {
+		if _, _, found := p.FindCollectionRolePath(role); found {
+			return filepath.Join("collections", role.CollectionRelPath(), "roles", role.Role)
+		}
+	}
+
+	return filepath.Join("roles", roleName)
+}
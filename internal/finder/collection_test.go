@@ -0,0 +1,78 @@
+package finder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCollectionRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		roleName string
+		expected CollectionRole
+		ok       bool
+	}{
+		{
+			name:     "fully qualified role",
+			roleName: "acme.platform.nginx",
+			expected: CollectionRole{Namespace: "acme", Collection: "platform", Role: "nginx"},
+			ok:       true,
+		},
+		{
+			name:     "short role name",
+			roleName: "nginx",
+			ok:       false,
+		},
+		{
+			name:     "galaxy role name",
+			roleName: "geerlingguy.nginx",
+			ok:       false,
+		},
+		{
+			name:     "role path",
+			roleName: "../roles/a.b.c",
+			ok:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := ParseCollectionRole(tt.roleName)
+			if ok != tt.ok || result != tt.expected {
+				t.Errorf("ParseCollectionRole() = %v, %v, want %v, %v", result, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFindCollectionRolePath(t *testing.T) {
+	collectionsDir := t.TempDir()
+	rolePath := filepath.Join(collectionsDir, "ansible_collections", "acme", "platform", "roles", "nginx")
+	if err := os.MkdirAll(rolePath, 0755); err != nil {
+		t.Fatalf("Failed to create role directory: %v", err)
+	}
+
+	paths := SearchPaths{Collections: []string{filepath.Join(t.TempDir(), "missing"), collectionsDir}}
+
+	found, collectionPath, ok := paths.FindCollectionRolePath(CollectionRole{Namespace: "acme", Collection: "platform", Role: "nginx"})
+	if !ok || found != rolePath {
+		t.Errorf("FindCollectionRolePath() = %v, %v, want %v, true", found, ok, rolePath)
+	}
+	if collectionPath != filepath.Join(collectionsDir, "ansible_collections", "acme", "platform") {
+		t.Errorf("FindCollectionRolePath() collection path = %v", collectionPath)
+	}
+
+	// Short names resolve through the play-level collections keyword
+	if name := paths.QualifyRoleName("nginx", []string{"other.coll", "acme.platform"}); name != "acme.platform.nginx" {
+		t.Errorf("QualifyRoleName() = %v, want acme.platform.nginx", name)
+	}
+
+	if name := paths.QualifyRoleName("haproxy", []string{"acme.platform"}); name != "haproxy" {
+		t.Errorf("QualifyRoleName() = %v, want haproxy", name)
+	}
+
+	if relPath := paths.RoleTempRelPath("acme.platform.nginx"); relPath != filepath.Join("collections", "ansible_collections", "acme", "platform", "roles", "nginx") {
+		t.Errorf("RoleTempRelPath() = %v", relPath)
+	}
+}
@@ -0,0 +1,217 @@
+package finder
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Default roles_path used by Ansible when none is configured
+var defaultRolesPath = []string{"~/.ansible/roles", "/usr/share/ansible/roles", "/etc/ansible/roles"}
+
+// Finds the ansible.cfg Ansible would use, following its search order:
+// ANSIBLE_CONFIG, ./ansible.cfg, ~/.ansible.cfg, /etc/ansible/ansible.cfg
+func FindAnsibleConfig() (string, bool) {
+	candidates := []string{
+		os.Getenv("ANSIBLE_CONFIG"),
+		"ansible.cfg",
+		"~/.ansible.cfg",
+		"/etc/ansible/ansible.cfg",
+	}
+
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+
+		path := expandHome(candidate)
+
+		// ANSIBLE_CONFIG may point at a directory containing ansible.cfg
+		if dirExists(path) {
+			path = filepath.Join(path, "ansible.cfg")
+		}
+
+		if fileExists(path) {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+// Reads a value from a section of an ansible.cfg file the way Ansible's ini
+// parser does: inline comments are dropped and indented lines continue the
+// value, joined with newlines
+func ReadConfigValue(configPath, section, key string) (string, bool, error) {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return "", false, fmt.Errorf("opening config file: %w", err)
+	}
+	defer file.Close()
+
+	currentSection := ""
+	var valueLines []string
+	inValue := false // Whether indented lines continue a value
+	found := false
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		rawLine := scanner.Text()
+		line := strings.TrimSpace(rawLine)
+
+		// Skip blank lines and comments, also between continuation lines
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		line = strings.TrimSpace(stripInlineComment(line))
+
+		// Indented lines continue the value of the previous key
+		if inValue && rawLine != strings.TrimLeft(rawLine, " \t") {
+			if found {
+				valueLines = append(valueLines, line)
+			}
+			continue
+		}
+		if found {
+			break
+		}
+		inValue = false
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			currentSection = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		name, value, ok := splitConfigLine(line)
+		inValue = ok
+		if ok && currentSection == section && name == key {
+			valueLines = append(valueLines, value)
+			found = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", false, fmt.Errorf("reading config file: %w", err)
+	}
+
+	return strings.Join(valueLines, "\n"), found, nil
+}
+
+// Removes an inline comment, a ";" preceded by whitespace. Like Ansible,
+// "#" only starts a comment at the beginning of a line, so it may appear in
+// values.
+func stripInlineComment(line string) string {
+	for i := 1; i < len(line); i++ {
+		if line[i] == ';' && (line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Splits a "key = value" or "key: value" line
+func splitConfigLine(line string) (string, string, bool) {
+	index := strings.IndexAny(line, "=:")
+	if index < 0 {
+		return "", "", false
+	}
+
+	name := strings.TrimSpace(line[:index])
+	value := strings.TrimSpace(line[index+1:])
+	return name, value, true
+}
+
+// Returns the configured path list for a setting, preferring the environment
+// variable over ansible.cfg. Relative entries in ansible.cfg are resolved
+// against the directory of the config file.
+func configuredPathList(envName, key string, defaults []string) []string {
+	if value := os.Getenv(envName); value != "" {
+		return splitPathList(value, "")
+	}
+
+	configPath, found := FindAnsibleConfig()
+	if !found {
+		return defaults
+	}
+
+	value, found, err := ReadConfigValue(configPath, "defaults", key)
+	if err != nil || !found || value == "" {
+		return defaults
+	}
+
+	return splitPathList(value, filepath.Dir(configPath))
+}
+
+// Splits a colon-separated path list, expanding ~ and resolving relative entries
+func splitPathList(value, baseDir string) []string {
+	var paths []string
+	for _, entry := range strings.Split(value, string(os.PathListSeparator)) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		entry = expandHome(entry)
+		if baseDir != "" && !filepath.IsAbs(entry) {
+			entry = filepath.Join(baseDir, entry)
+		}
+		paths = append(paths, entry)
+	}
+	return paths
+}
+
+// Returns the role search paths in Ansible's order: the playbook-adjacent
+// roles directories, then roles_path (ANSIBLE_ROLES_PATH or ansible.cfg),
+// then the playbook directories themselves
+func DiscoverRoleSearchPaths(playbookDirs []string) []string {
+	var paths []string
+	for _, dir := range playbookDirs {
+		paths = append(paths, filepath.Join(dir, "roles"))
+	}
+	paths = append(paths, configuredPathList("ANSIBLE_ROLES_PATH", "roles_path", expandAll(defaultRolesPath))...)
+	paths = append(paths, playbookDirs...)
+
+	// Keep finding roles relative to the current directory as before
+	paths = append(paths, "roles")
+
+	return removeDuplicatePaths(paths)
+}
+
+// Expands ~ in every path
+func expandAll(paths []string) []string {
+	expanded := make([]string, 0, len(paths))
+	for _, path := range paths {
+		expanded = append(expanded, expandHome(path))
+	}
+	return expanded
+}
+
+// Expands a leading ~ to the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// Removes duplicate paths while keeping order
+func removeDuplicatePaths(paths []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		result = append(result, path)
+	}
+	return result
+}
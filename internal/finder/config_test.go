@@ -0,0 +1,127 @@
+package finder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadConfigValue(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "ansible.cfg")
+	content := `# Project settings
+[defaults]
+inventory = hosts
+roles_path = ../shared-roles:~/.ansible/roles
+; comment
+
+[ssh_connection]
+roles_path = ignored
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	value, found, err := ReadConfigValue(configPath, "defaults", "roles_path")
+	if err != nil {
+		t.Fatalf("ReadConfigValue() error = %v", err)
+	}
+	if !found || value != "../shared-roles:~/.ansible/roles" {
+		t.Errorf("ReadConfigValue() = %q, %v, want %q, true", value, found, "../shared-roles:~/.ansible/roles")
+	}
+
+	_, found, err = ReadConfigValue(configPath, "defaults", "collections_path")
+	if err != nil || found {
+		t.Errorf("ReadConfigValue() for missing key = %v, %v, want false, nil", found, err)
+	}
+}
+
+func TestReadConfigValue_CommentsAndContinuations(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "ansible.cfg")
+	content := `[defaults]
+inventory = hosts ; local inventory
+host_key_checking = False
+    roles_path = continued
+roles_path = roles:
+    ../shared-roles: ; team roles
+    # disabled: ../old-roles
+
+    ~/.ansible/roles
+collections_path = collections
+vault_password_file = pass#word;file
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"inventory", "hosts"},
+		{"roles_path", "roles:\n../shared-roles:\n~/.ansible/roles"},
+		{"collections_path", "collections"},
+		{"vault_password_file", "pass#word;file"},
+	}
+
+	for _, tt := range tests {
+		value, found, err := ReadConfigValue(configPath, "defaults", tt.key)
+		if err != nil || !found || value != tt.expected {
+			t.Errorf("ReadConfigValue(%s) = %q, %v, %v, want %q, true, nil", tt.key, value, found, err, tt.expected)
+		}
+	}
+
+	// Continuation lines split into separate paths
+	paths := splitPathList("roles:\n../shared-roles:\n/usr/share/ansible/roles", "")
+	if expected := []string{"roles", "../shared-roles", "/usr/share/ansible/roles"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("splitPathList() = %q, want %q", paths, expected)
+	}
+}
+
+func TestDiscoverRoleSearchPaths(t *testing.T) {
+	projectDir := t.TempDir()
+	home, _ := os.UserHomeDir()
+
+	configPath := filepath.Join(projectDir, "ansible.cfg")
+	content := `[defaults]
+roles_path = ../shared-roles:~/.ansible/roles
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Run("roles_path from ansible.cfg", func(t *testing.T) {
+		t.Setenv("ANSIBLE_CONFIG", configPath)
+		t.Setenv("ANSIBLE_ROLES_PATH", "")
+
+		expected := []string{
+			filepath.Join(projectDir, "roles"),
+			filepath.Join(filepath.Dir(projectDir), "shared-roles"),
+			filepath.Join(home, ".ansible", "roles"),
+			projectDir,
+			"roles",
+		}
+
+		result := DiscoverRoleSearchPaths([]string{projectDir})
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("DiscoverRoleSearchPaths() = %v, want %v", result, expected)
+		}
+	})
+
+	t.Run("ANSIBLE_ROLES_PATH overrides ansible.cfg", func(t *testing.T) {
+		t.Setenv("ANSIBLE_CONFIG", configPath)
+		t.Setenv("ANSIBLE_ROLES_PATH", "/opt/roles")
+
+		expected := []string{
+			filepath.Join(projectDir, "roles"),
+			"/opt/roles",
+			projectDir,
+			"roles",
+		}
+
+		result := DiscoverRoleSearchPaths([]string{projectDir})
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("DiscoverRoleSearchPaths() = %v, want %v", result, expected)
+		}
+	})
+}
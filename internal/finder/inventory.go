@@ -6,7 +6,7 @@ import (
 	"path/filepath"
 )
 
-// Finds an inventory file at the specified path
+// Finds an inventory source, a file or a directory, at the specified path
 func FindInventory(path string) (string, error) {
 	// Check if the path is absolute or relative
 	var fullPath string
@@ -16,15 +16,20 @@ func FindInventory(path string) (string, error) {
 		fullPath = filepath.Clean(path)
 	}
 
-	// Check if file exists
-	info, err := os.Stat(fullPath)
-	if err != nil {
-		return "", fmt.Errorf("inventory file not found: %s", path)
-	}
-
-	if info.IsDir() {
-		return "", fmt.Errorf("inventory path is a directory, not a file: %s", path)
+	// Check if the source exists
+	if _, err := os.Stat(fullPath); err != nil {
+		return "", fmt.Errorf("inventory not found: %s", path)
 	}
 
 	return fullPath, nil
 }
+
+// Returns the directory Ansible looks for an inventory source's group_vars
+// and host_vars in: the source itself when it is a directory, otherwise the
+// directory containing it
+func InventoryDir(path string) string {
+	if dirExists(path) {
+		return path
+	}
+	return filepath.Dir(path)
+}
@@ -4,28 +4,51 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-// Gets the directory path for a role
-func FindRolePath(roleName string) (string, error) {
-	rolePath := filepath.Join("roles", roleName)
+// Directories searched for roles and collections, in order
+type SearchPaths struct {
+	Roles       []string // Directories holding roles, "roles" when empty
+	Collections []string // Directories holding collections
+}
 
-	// Check if directory exists
-	info, err := os.Stat(rolePath)
-	if err != nil {
-		return "", fmt.Errorf("role directory not found: %s", rolePath)
+// Returns the directories searched for roles
+func (p SearchPaths) roles() []string {
+	if len(p.Roles) == 0 {
+		return []string{"roles"}
 	}
+	return p.Roles
+}
+
+// Gets the directory path for a role, looking in installed collections for
+// fully qualified names and then searching each role search path in order
+func (p SearchPaths) FindRolePath(roleName string) (string, error) {
+	if role, ok := ParseCollectionRole(roleName); ok {
+		if rolePath, _, found := p.FindCollectionRolePath(role); found {
+			return rolePath, nil
+		}
+	}
+
+	for _, searchPath := range p.roles() {
+		rolePath := filepath.Join(searchPath, roleName)
+
+		// Check if directory exists
+		info, err := os.Stat(rolePath)
+		if err != nil || !info.IsDir() {
+			continue
+		}
 
-	if !info.IsDir() {
-		return "", fmt.Errorf("role path is not a directory: %s", rolePath)
+		return rolePath, nil
 	}
 
-	return rolePath, nil
+	return "", fmt.Errorf("role directory not found: %s (searched %s)",
+		roleName, strings.Join(p.roles(), ", "))
 }
 
 // Finds the meta/main.yml file for a role
-func FindRoleMetaFile(roleName string) (string, bool, error) {
-	rolePath, err := FindRolePath(roleName)
+func (p SearchPaths) FindRoleMetaFile(roleName string) (string, bool, error) {
+	rolePath, err := p.FindRolePath(roleName)
 	if err != nil {
 		return "", false, err
 	}
@@ -53,3 +76,24 @@ func FindRoleMetaFile(roleName string) (string, bool, error) {
 	// Not finding a meta file is not an error
 	return "", false, nil
 }
+
+// Finds a file referenced by tasks_from, vars_from or defaults_from
+// in the given subdirectory of a role
+func (p SearchPaths) FindRoleEntryFile(roleName, subdir, name string) (string, bool) {
+	rolePath, err := p.FindRolePath(roleName)
+	if err != nil {
+		return "", false
+	}
+
+	// The extension may be omitted
+	candidates := []string{name, name + ".yml", name + ".yaml"}
+	for _, candidate := range candidates {
+		path := filepath.Join(rolePath, subdir, candidate)
+		info, err := os.Stat(path)
+		if err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+
+	return "", false
+}
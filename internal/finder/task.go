@@ -2,16 +2,22 @@ package finder
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 )
 
-// Finds task files for a role
-func FindRoleTasks(roleName string) ([]string, error) {
-	tasksDir := filepath.Join("roles", roleName, "tasks")
+// Finds task files for a role, including those in subdirectories of tasks/
+func (p SearchPaths) FindRoleTasks(roleName string) ([]string, error) {
+	rolePath, err := p.FindRolePath(roleName)
+	if err != nil {
+		// A missing role has no tasks
+		return []string{}, nil
+	}
+	tasksDir := filepath.Join(rolePath, "tasks")
 
 	// Check if tasks directory exists
-	_, err := os.Stat(tasksDir)
+	_, err = os.Stat(tasksDir)
 	if os.IsNotExist(err) {
 		// Return empty slice instead of error for missing tasks directory
 		return []string{}, nil
@@ -19,51 +25,52 @@ func FindRoleTasks(roleName string) ([]string, error) {
 		return nil, fmt.Errorf("checking tasks directory: %w", err)
 	}
 
-	// Find .yml files
-	ymlFiles, err := filepath.Glob(filepath.Join(tasksDir, "*.yml"))
-	if err != nil {
-		return nil, fmt.Errorf("error searching .yml files: %w", err)
-	}
-
-	// Find .yaml files
-	yamlFiles, err := filepath.Glob(filepath.Join(tasksDir, "*.yaml"))
+	// Find .yml and .yaml files
+	var taskFiles []string
+	err = filepath.WalkDir(tasksDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && isYAMLFile(path) {
+			taskFiles = append(taskFiles, path)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error searching .yaml files: %w", err)
+		return nil, fmt.Errorf("error searching task files: %w", err)
 	}
 
-	// Combine results
-	return append(ymlFiles, yamlFiles...), nil
+	return taskFiles, nil
 }
 
-// Finds the main.yml task file for a role
-func FindRoleMainTask(roleName string) (string, bool, error) {
-	tasksDir := filepath.Join("roles", roleName, "tasks")
-
-	// Check if directory exists
-	_, err := os.Stat(tasksDir)
-	if os.IsNotExist(err) {
-		return "", false, fmt.Errorf("role tasks directory not found: %s", tasksDir)
-	}
-
-	// Define possible main task paths
-	mainPaths := []string{
-		filepath.Join(tasksDir, "main.yml"),
-		filepath.Join(tasksDir, "main.yaml"),
+// Finds a task file referenced by include_tasks or import_tasks,
+// searching each directory in order
+func FindIncludedTaskFile(includePath string, searchDirs []string) (string, bool) {
+	if filepath.IsAbs(includePath) {
+		return includePath, fileExists(includePath)
 	}
 
-	// Check each possible path
-	for _, mainPath := range mainPaths {
-		info, err := os.Stat(mainPath)
-		if err != nil {
-			continue
+	for _, dir := range searchDirs {
+		candidate := filepath.Clean(filepath.Join(dir, includePath))
+		if fileExists(candidate) {
+			return candidate, true
 		}
+	}
 
-		if info.IsDir() {
-			continue
-		}
+	return "", false
+}
 
-		return mainPath, true, nil
+// Checks if a regular file exists
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
 	}
+	return !info.IsDir()
+}
 
-	return "", false, fmt.Errorf("main task file not found for role: %s", roleName)
+// Checks if a path has a YAML extension
+func isYAMLFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yml" || ext == ".yaml"
 }
@@ -11,36 +11,41 @@ type VarsDirectories struct {
 	HostVars  string
 }
 
-// Searches for group_vars and host_vars directories
-func FindVarsDirectories(playbookPath, inventoryPath string) VarsDirectories {
-	var result VarsDirectories
+// Checks if neither directory was found
+func (v VarsDirectories) IsEmpty() bool {
+	return v.GroupVars == "" && v.HostVars == ""
+}
 
-	// Check in current directory root level
-	if dirExists("group_vars") {
-		result.GroupVars = "group_vars"
-	}
+// Holds the vars directories Ansible loads for a run, by where they are found
+type VarsLocations struct {
+	Inventory []VarsDirectories // Next to the inventory sources, in source order
+	Playbook  VarsDirectories   // Next to the playbook
+}
 
-	if dirExists("host_vars") {
-		result.HostVars = "host_vars"
-	}
+// Searches for the group_vars and host_vars directories next to each
+// inventory source, or inside inventory directories, and next to the playbook
+func FindVarsDirectories(playbookPath string, inventoryPaths []string) VarsLocations {
+	var result VarsLocations
 
-	// Check in the same directory as the playbook
-	playbookDir := filepath.Dir(playbookPath)
-	if playbookDir != "." {
-		checkDirInLocation(playbookDir, "group_vars", &result.GroupVars)
-		checkDirInLocation(playbookDir, "host_vars", &result.HostVars)
+	for _, inventoryPath := range inventoryPaths {
+		if dirs := findVarsIn(InventoryDir(inventoryPath)); !dirs.IsEmpty() {
+			result.Inventory = append(result.Inventory, dirs)
+		}
 	}
 
-	// Check in the same directory as the inventory
-	inventoryDir := filepath.Dir(inventoryPath)
-	if inventoryDir != "." && inventoryDir != playbookDir {
-		checkDirInLocation(inventoryDir, "group_vars", &result.GroupVars)
-		checkDirInLocation(inventoryDir, "host_vars", &result.HostVars)
-	}
+	result.Playbook = findVarsIn(filepath.Dir(playbookPath))
 
 	return result
 }
 
+// Returns the group_vars and host_vars directories in a location
+func findVarsIn(location string) VarsDirectories {
+	var result VarsDirectories
+	checkDirInLocation(location, "group_vars", &result.GroupVars)
+	checkDirInLocation(location, "host_vars", &result.HostVars)
+	return result
+}
+
 // Checks if a directory exists
 func dirExists(path string) bool {
 	info, err := os.Stat(path)
@@ -0,0 +1,83 @@
+package finder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func makeDirs(t *testing.T, root string, dirs ...string) {
+	t.Helper()
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+}
+
+func TestFindVarsDirectories_InventoryDirectory(t *testing.T) {
+	projectDir := t.TempDir()
+	makeDirs(t, projectDir,
+		"playbooks",
+		"inventories/prod/group_vars",
+		"inventories/prod/host_vars",
+	)
+
+	playbook := filepath.Join(projectDir, "playbooks", "site.yml")
+	inventories := []string{
+		filepath.Join(projectDir, "hosts.ini"),
+		filepath.Join(projectDir, "inventories", "prod"),
+	}
+
+	result := FindVarsDirectories(playbook, inventories)
+
+	if len(result.Inventory) != 1 {
+		t.Fatalf("Expected vars next to 1 inventory source, got %d", len(result.Inventory))
+	}
+	if want := filepath.Join(projectDir, "inventories/prod/group_vars"); result.Inventory[0].GroupVars != want {
+		t.Errorf("GroupVars = %q, want %q", result.Inventory[0].GroupVars, want)
+	}
+	if want := filepath.Join(projectDir, "inventories/prod/host_vars"); result.Inventory[0].HostVars != want {
+		t.Errorf("HostVars = %q, want %q", result.Inventory[0].HostVars, want)
+	}
+	if !result.Playbook.IsEmpty() {
+		t.Errorf("Expected no playbook vars, got %+v", result.Playbook)
+	}
+}
+
+func TestFindVarsDirectories_PlaybookAndInventory(t *testing.T) {
+	projectDir := t.TempDir()
+	makeDirs(t, projectDir,
+		"group_vars",
+		"inventories/prod/group_vars",
+		"inventories/stage/host_vars",
+	)
+
+	playbook := filepath.Join(projectDir, "site.yml")
+	inventories := []string{
+		filepath.Join(projectDir, "inventories", "prod", "hosts.ini"),
+		filepath.Join(projectDir, "inventories", "stage", "hosts.ini"),
+	}
+
+	result := FindVarsDirectories(playbook, inventories)
+
+	expected := []VarsDirectories{
+		{GroupVars: filepath.Join(projectDir, "inventories/prod/group_vars")},
+		{HostVars: filepath.Join(projectDir, "inventories/stage/host_vars")},
+	}
+	if len(result.Inventory) != len(expected) {
+		t.Fatalf("Expected vars next to %d inventory sources, got %d", len(expected), len(result.Inventory))
+	}
+	for i, want := range expected {
+		if result.Inventory[i] != want {
+			t.Errorf("Inventory[%d] = %+v, want %+v", i, result.Inventory[i], want)
+		}
+	}
+
+	if want := filepath.Join(projectDir, "group_vars"); result.Playbook.GroupVars != want {
+		t.Errorf("Playbook GroupVars = %q, want %q", result.Playbook.GroupVars, want)
+	}
+	if result.Playbook.HostVars != "" {
+		t.Errorf("Playbook HostVars = %q, want empty", result.Playbook.HostVars)
+	}
+}
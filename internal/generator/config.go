@@ -0,0 +1,91 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zinrai/ansible-template-render/internal/ansible"
+	"github.com/zinrai/ansible-template-render/internal/logger"
+	"github.com/zinrai/ansible-template-render/internal/utils"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Configuration file read from the current directory when no path is given
+const DefaultConfigFile = ".ansible-template-render.yml"
+
+// Holds the settings read from the configuration file
+type Config struct {
+	TemplateModules []ansible.TemplateModule `yaml:"template_modules"`
+	Validators      map[string]string        `yaml:"validators"` // Local commands replacing validate commands, by executable name
+}
+
+// Loads the configuration file; a missing default file yields an empty configuration
+func LoadConfig(path string) (*Config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = DefaultConfigFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return &Config{}, nil
+		}
+		return nil, utils.NewFileNotFoundError(path, err)
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, utils.NewConfigError(fmt.Sprintf("parsing %s", path), err)
+	}
+
+	for i, module := range config.TemplateModules {
+		if module.Name == "" {
+			return nil, utils.NewConfigError(fmt.Sprintf("template module %d in %s has no name", i, path), nil)
+		}
+	}
+
+	logger.Info("Loaded configuration", "path", path)
+	return &config, nil
+}
+
+// Returns the validators from the configuration file and the command line,
+// where NAME=COMMAND on the command line overrides the file
+func configureValidators(config *Config, specs []string) (map[string]string, error) {
+	validators := make(map[string]string, len(config.Validators)+len(specs))
+	for name, command := range config.Validators {
+		validators[name] = command
+	}
+
+	for _, spec := range specs {
+		name, command, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.TrimSpace(command) == "" {
+			return nil, utils.NewConfigError(fmt.Sprintf("validator %q is not of the form NAME=COMMAND", spec), nil)
+		}
+		validators[name] = strings.TrimSpace(command)
+	}
+
+	return validators, nil
+}
+
+// Returns the template-like modules from the configuration file and the command line
+func configureTemplateModules(config *Config, specs []string) ([]ansible.TemplateModule, error) {
+	modules := append([]ansible.TemplateModule(nil), config.TemplateModules...)
+
+	for _, spec := range specs {
+		module, err := ansible.ParseTemplateModule(spec)
+		if err != nil {
+			return nil, utils.NewConfigError("parsing template module", err)
+		}
+		modules = append(modules, module)
+	}
+
+	if len(modules) > 0 {
+		logger.Info("Additional template modules", "modules", modules)
+	}
+
+	return modules, nil
+}
@@ -1,76 +1,235 @@
 package generator
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/zinrai/ansible-template-render/internal/ansible"
+	"github.com/zinrai/ansible-template-render/internal/compare"
 	"github.com/zinrai/ansible-template-render/internal/copier"
 	"github.com/zinrai/ansible-template-render/internal/executor"
 	"github.com/zinrai/ansible-template-render/internal/finder"
 	"github.com/zinrai/ansible-template-render/internal/logger"
+	"github.com/zinrai/ansible-template-render/internal/manifest"
 	"github.com/zinrai/ansible-template-render/internal/processor"
 	"github.com/zinrai/ansible-template-render/internal/utils"
 )
 
+// Holds the settings for a template generation run
+type Options struct {
+	PlaybookPath   string
+	InventoryPaths []string // Inventory sources, files or directories
+	InventoryMode  string   // How the inventory is made local, processor.InventoryFlatten when empty
+	Limit          string   // Ansible host pattern selecting the hosts to render for, all when empty
+	AnsibleArgs    []string // Additional arguments for ansible-playbook, passed as given
+	OutputDir      string   // Directory receiving the rendered files, "output" when empty
+	CompareDir     string   // Reference tree to diff the rendered files against, none when empty
+	BaselineDir    string   // Directory seeding files edited by lineinfile, blockinfile and ini_file
+	GenerateOnly   bool     // Generate modified files without executing Ansible
+	PerHost        bool     // Render templates for every host instead of once per play
+	CopySources    bool     // Also render copy tasks with a src file, not only inline content
+	ConfigPath     string   // Configuration file, DefaultConfigFile when empty
+	ModuleSpecs    []string // Additional template modules, NAME or NAME=PARAM[,PARAM...]
+	Validators     []string // Local validate commands, NAME=COMMAND with %s standing for the file
+	NoValidate     bool     // Skip running validate commands against rendered files
+	KeepWorkspace  bool     // Keep the temporary workspace after rendering
+}
+
+// Reported when the rendered files differ from the reference tree
+var ErrDifferences = errors.New("rendered files differ from the reference")
+
 // Runs the template generation process for a single playbook
-func RunTemplateGeneration(playbookPath, inventoryPath, ansibleArgs string, generateOnly bool) error {
-	playbookName := strings.TrimSuffix(filepath.Base(playbookPath), filepath.Ext(playbookPath))
+func RunTemplateGeneration(opts Options) error {
+	playbookName := strings.TrimSuffix(filepath.Base(opts.PlaybookPath), filepath.Ext(opts.PlaybookPath))
 	logger.Info("Processing playbook", "name", playbookName)
 
-	if err := processPlaybook(playbookPath, inventoryPath, ansibleArgs, playbookName, generateOnly); err != nil {
+	if err := processPlaybook(opts, playbookName); err != nil {
 		return utils.NewError(utils.ErrUnknown, fmt.Sprintf("processing playbook %s", playbookName), err)
 	}
 	return nil
 }
 
-func processPlaybook(playbookPath, inventoryPath, ansibleArgs, playbookName string, generateOnly bool) error {
-	foundPlaybook, err := finder.FindPlaybook(playbookPath)
+func processPlaybook(opts Options, playbookName string) (err error) {
+	foundPlaybook, err := finder.FindPlaybook(opts.PlaybookPath)
 	if err != nil {
-		return utils.NewFileNotFoundError(playbookPath, err)
+		return utils.NewFileNotFoundError(opts.PlaybookPath, err)
 	}
 	logger.Info("Found playbook", "path", foundPlaybook)
 
-	foundInventory, err := finder.FindInventory(inventoryPath)
+	foundInventories := make([]string, 0, len(opts.InventoryPaths))
+	for _, inventoryPath := range opts.InventoryPaths {
+		foundInventory, err := finder.FindInventory(inventoryPath)
+		if err != nil {
+			return utils.NewFileNotFoundError(inventoryPath, err)
+		}
+		logger.Info("Found inventory", "path", foundInventory)
+		foundInventories = append(foundInventories, foundInventory)
+	}
+
+	varsDirectories := finder.FindVarsDirectories(foundPlaybook, foundInventories)
+
+	inventoryMode, err := resolveInventoryMode(opts.InventoryMode)
 	if err != nil {
-		return utils.NewFileNotFoundError(inventoryPath, err)
+		return err
 	}
-	logger.Info("Found inventory", "path", foundInventory)
 
-	varsDirectories := finder.FindVarsDirectories(foundPlaybook, foundInventory)
+	config, err := LoadConfig(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+	templateModules, err := configureTemplateModules(config, opts.ModuleSpecs)
+	if err != nil {
+		return err
+	}
+	validators, err := configureValidators(config, opts.Validators)
+	if err != nil {
+		return err
+	}
+
+	compareDir, err := resolveCompareDir(opts.CompareDir, opts.GenerateOnly)
+	if err != nil {
+		return err
+	}
+	opts.CompareDir = compareDir
+
+	// Without an output directory, a comparison renders into the workspace
+	var outputDir string
+	if compareDir == "" || opts.OutputDir != "" {
+		outputDir, err = prepareOutputDir(opts.OutputDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	baselineDir, err := resolveBaselineDir(opts.BaselineDir)
+	if err != nil {
+		return err
+	}
 
 	env, err := setupAndValidateEnvironment(playbookName)
 	if err != nil {
 		return err
 	}
+	env.OutputDir = outputDir
 	defer restoreOriginalDirectory(env)
 
-	// Convert inventory for local execution using ansible-inventory
-	tempInventoryPath, err := processor.ModifyInventoryForLocalExecution(foundInventory, env.TempDir)
-	if err != nil {
-		return utils.NewError(utils.ErrUnknown, "converting inventory for local execution", err)
+	// Generate-only mode leaves the workspace behind to be run manually
+	defer func() {
+		cleanupWorkspace(env, opts.KeepWorkspace || (opts.GenerateOnly && err == nil))
+	}()
+
+	if env.OutputDir == "" {
+		env.OutputDir, err = prepareOutputDir(filepath.Join(env.TempDir, "output"))
+		if err != nil {
+			return err
+		}
 	}
-	env.InventoryPath = tempInventoryPath
-	logger.Info("Converted inventory for local execution", "path", tempInventoryPath)
 
-	err = copier.CopyVarsDirectories(varsDirectories, env.TempDir)
+	limitedHosts, err := localizeInventory(env, inventoryMode, foundInventories, opts.Limit, &varsDirectories)
 	if err != nil {
-		return utils.NewError(utils.ErrUnknown, "copying vars directories", err)
+		return err
+	}
+	if opts.Limit != "" {
+		if len(limitedHosts) == 0 {
+			return utils.NewConfigError(fmt.Sprintf("no hosts match limit %q", opts.Limit), nil)
+		}
+		logger.Info("Limiting hosts", "pattern", opts.Limit, "hosts", limitedHosts)
 	}
 
-	hasTemplates, err := processPlaybookContent(foundPlaybook, env, playbookName)
+	renderOpts := ansible.RenderOptions{
+		PlaybookName:    playbookName,
+		OutputDir:       env.OutputDir,
+		BaselineDir:     baselineDir,
+		PerHost:         opts.PerHost,
+		RecordsDir:      env.RecordsDir(),
+		CopySources:     opts.CopySources,
+		TemplateModules: templateModules,
+	}
+	if renderOpts.BaselineDir != "" {
+		logger.Info("Rendering file edits against baseline", "baseline", renderOpts.BaselineDir)
+	}
+	if renderOpts.PerHost {
+		if opts.Limit != "" {
+			logger.Info("Rendering templates per host", "output", filepath.Join(env.OutputDir, "<inventory_hostname>"), "hosts", limitedHosts)
+		} else {
+			logger.Info("Rendering templates per host", "output", filepath.Join(env.OutputDir, "<inventory_hostname>"))
+		}
+	}
+
+	hasTemplates, err := processPlaybookContent(foundPlaybook, env, renderOpts)
 	if err != nil {
 		return err
 	}
 
+	// Vars go next to the generated inventory and playbook, so Ansible
+	// applies its own precedence between them
+	err = copier.CopyVarsDirectories(varsDirectories, env.InventoryDir(), filepath.Dir(env.TempPlaybookPath))
+	if err != nil {
+		return utils.NewError(utils.ErrUnknown, "copying vars directories", err)
+	}
+
 	if !hasTemplates {
 		logger.Info("No template tasks found in playbook", "name", playbookName)
 		return nil
 	}
 
-	return executeOrGenerateInstructions(env, ansibleArgs, generateOnly)
+	return executeOrGenerateInstructions(env, opts, validators)
+}
+
+// Checks the inventory mode, defaulting to flattening the inventory
+func resolveInventoryMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return processor.InventoryFlatten, nil
+	case processor.InventoryFlatten, processor.InventoryKeep:
+		return mode, nil
+	default:
+		return "", utils.NewConfigError(fmt.Sprintf("unknown inventory mode %q, expected %s or %s",
+			mode, processor.InventoryFlatten, processor.InventoryKeep), nil)
+	}
+}
+
+// Points the workspace at an inventory whose hosts use the local connection.
+// With a limit, which ansible-playbook applies in either mode so that groups
+// and hostvars keep every host, returns the hosts matching it.
+func localizeInventory(env *Environment, mode string, inventoryPaths []string, limit string, varsDirectories *finder.VarsLocations) ([]string, error) {
+	if mode == processor.InventoryKeep {
+		sources, err := processor.KeepInventoryForLocalExecution(inventoryPaths)
+		if err != nil {
+			return nil, utils.NewError(utils.ErrUnknown, "keeping inventory for local execution", err)
+		}
+		env.InventoryPaths = sources
+		env.ExtraVars = []string{processor.LocalConnectionVar}
+
+		// Ansible reads the vars next to the original sources itself
+		varsDirectories.Inventory = nil
+		logger.Info("Using original inventory with local connection", "paths", sources, "extra_vars", env.ExtraVars)
+	} else {
+		// Convert inventory for local execution using ansible-inventory, placed
+		// in its own directory, where the inventory-adjacent vars are copied
+		tempInventoryPath, err := processor.ModifyInventoryForLocalExecution(inventoryPaths, env.InventoryDir())
+		if err != nil {
+			return nil, utils.NewError(utils.ErrUnknown, "converting inventory for local execution", err)
+		}
+		env.InventoryPaths = []string{env.relativePath(tempInventoryPath)}
+		logger.Info("Converted inventory for local execution", "path", tempInventoryPath)
+	}
+
+	if limit == "" {
+		return nil, nil
+	}
+	env.Limit = limit
+
+	// Both modes hold the hosts of the original sources, so Ansible
+	// matches the limit against those
+	limitedHosts, err := processor.LimitInventoryHosts(inventoryPaths, limit)
+	if err != nil {
+		return nil, utils.NewError(utils.ErrUnknown, "matching limit", err)
+	}
+	return limitedHosts, nil
 }
 
 func setupAndValidateEnvironment(playbookName string) (*Environment, error) {
@@ -87,44 +246,276 @@ func restoreOriginalDirectory(env *Environment) {
 	}
 }
 
-func executeOrGenerateInstructions(env *Environment, ansibleArgs string, generateOnly bool) error {
-	outputDir := filepath.Join(env.TempDir, "output")
+// Removes the temporary workspace, or reports where it was kept
+func cleanupWorkspace(env *Environment, keep bool) {
+	if keep {
+		logger.Info("Workspace kept", "dir", env.TempDir)
+		return
+	}
+
+	if err := os.RemoveAll(env.TempDir); err != nil {
+		logger.Warn("Failed to remove workspace", "dir", env.TempDir, "error", err)
+	}
+}
+
+// Creates the output directory, checking it is empty or holds an earlier
+// render, and returns its absolute path
+func prepareOutputDir(outputDir string) (string, error) {
+	if outputDir == "" {
+		outputDir = "output"
+	}
+
+	absOutputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return "", utils.NewError(utils.ErrUnknown, "resolving output directory", err)
+	}
+
+	if _, err := earlierRenderEntries(absOutputDir); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(absOutputDir, 0755); err != nil {
+		return "", utils.NewError(utils.ErrUnknown, "creating output directory", err)
+	}
+
+	return absOutputDir, nil
+}
+
+// Returns the absolute baseline directory, or an empty string when none is used
+func resolveBaselineDir(baselineDir string) (string, error) {
+	if baselineDir == "" {
+		return "", nil
+	}
+
+	absBaselineDir, err := filepath.Abs(baselineDir)
+	if err != nil {
+		return "", utils.NewError(utils.ErrUnknown, "resolving baseline directory", err)
+	}
+
+	info, err := os.Stat(absBaselineDir)
+	if err != nil {
+		return "", utils.NewFileNotFoundError(baselineDir, err)
+	}
+	if !info.IsDir() {
+		return "", utils.NewConfigError(fmt.Sprintf("baseline %s is not a directory", baselineDir), nil)
+	}
+
+	return absBaselineDir, nil
+}
 
+// Returns the absolute reference directory, or an empty string when the
+// rendered files are not compared
+func resolveCompareDir(compareDir string, generateOnly bool) (string, error) {
+	if compareDir == "" {
+		return "", nil
+	}
 	if generateOnly {
-		printGenerateOnlyInstructions(env, ansibleArgs)
+		return "", utils.NewConfigError("comparing rendered files requires running Ansible", nil)
+	}
+
+	absCompareDir, err := filepath.Abs(compareDir)
+	if err != nil {
+		return "", utils.NewError(utils.ErrUnknown, "resolving reference directory", err)
+	}
+
+	info, err := os.Stat(absCompareDir)
+	if err != nil {
+		return "", utils.NewFileNotFoundError(compareDir, err)
+	}
+	if !info.IsDir() {
+		return "", utils.NewConfigError(fmt.Sprintf("reference %s is not a directory", compareDir), nil)
+	}
+
+	return absCompareDir, nil
+}
+
+// Returns the entries of an output directory holding an earlier render,
+// recognised by a manifest written by this tool. Any other non-empty
+// directory is refused, so that no unrelated files are removed.
+func earlierRenderEntries(outputDir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(outputDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, utils.NewError(utils.ErrUnknown, "reading output directory", err)
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	if _, err := manifest.Load(filepath.Join(outputDir, manifest.FileName)); err != nil {
+		return nil, utils.NewConfigError(fmt.Sprintf("output directory %s is not empty and holds no earlier render",
+			outputDir), err)
+	}
+	return entries, nil
+}
+
+// Removes an earlier render from the output directory, whose files would
+// otherwise be kept instead of re-seeded and show up in the manifest and
+// comparisons. Called once the workspace is ready, right before the render
+// writes into the directory, so a failed setup leaves the earlier render.
+func clearOutputDir(outputDir string) error {
+	entries, err := earlierRenderEntries(outputDir)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	logger.Info("Removing earlier render", "output", outputDir)
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(outputDir, entry.Name())); err != nil {
+			return utils.NewError(utils.ErrUnknown, "clearing output directory", err)
+		}
+	}
+	return nil
+}
+
+func executeOrGenerateInstructions(env *Environment, opts Options, validators map[string]string) error {
+	// The generated playbook writes into the output directory when it runs
+	if err := clearOutputDir(env.OutputDir); err != nil {
+		return err
+	}
+
+	if opts.GenerateOnly {
+		printGenerateOnlyInstructions(env, opts.AnsibleArgs)
 		return nil
 	}
 
-	if err := executeAnsible(env, ansibleArgs); err != nil {
+	if err := executeAnsible(env, opts.AnsibleArgs); err != nil {
 		return err
 	}
 
-	logger.Info("Templates successfully rendered", "output", outputDir)
+	renderManifest, err := writeManifest(env)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Templates successfully rendered", "output", env.OutputDir)
+
+	var compareErr error
+	if opts.CompareDir != "" {
+		compareErr = compareRenderedFiles(env, opts.CompareDir)
+	}
+
+	if !opts.NoValidate {
+		if err := validateRenderedFiles(env, renderManifest, validators); err != nil {
+			return err
+		}
+	}
+
+	return compareErr
+}
+
+// Prints the differences between the rendered files and the reference tree
+func compareRenderedFiles(env *Environment, referenceDir string) error {
+	result, err := compare.Trees(referenceDir, env.OutputDir, manifest.FileName)
+	if err != nil {
+		return utils.NewError(utils.ErrUnknown, "comparing rendered files", err)
+	}
+
+	if !result.HasDifferences() {
+		logger.Info("Rendered files match the reference", "reference", referenceDir)
+		return nil
+	}
+
+	printComparison(result)
+	logger.Info("Rendered files differ from the reference",
+		"reference", referenceDir,
+		"added", len(result.Added),
+		"removed", len(result.Removed),
+		"changed", len(result.Changed))
+	return ErrDifferences
+}
+
+// Writes the manifest of rendered files to the output directory
+func writeManifest(env *Environment) (*manifest.Manifest, error) {
+	renderManifest, err := manifest.Build(env.RecordsDir(), env.OutputDir)
+	if err != nil {
+		return nil, utils.NewError(utils.ErrUnknown, "building manifest", err)
+	}
+
+	manifestPath := filepath.Join(env.OutputDir, manifest.FileName)
+	if err := renderManifest.Save(manifestPath); err != nil {
+		return nil, utils.NewError(utils.ErrUnknown, "saving manifest", err)
+	}
+
+	logger.Info("Manifest written", "path", manifestPath, "files", len(renderManifest.Files))
+	return renderManifest, nil
+}
+
+// Prints the diff of a comparison, followed by the added, removed and changed files
+func printComparison(result *compare.Result) {
+	fmt.Print(result.Diff)
+	for _, path := range result.Added {
+		fmt.Printf("Added:   %s\n", path)
+	}
+	for _, path := range result.Removed {
+		fmt.Printf("Removed: %s\n", path)
+	}
+	for _, path := range result.Changed {
+		fmt.Printf("Changed: %s\n", path)
+	}
+}
+
+// Runs the validate commands of the tasks against the files they rendered
+func validateRenderedFiles(env *Environment, renderManifest *manifest.Manifest, validators map[string]string) error {
+	var validations []executor.Validation
+	for _, file := range renderManifest.Files {
+		if file.Validate == "" {
+			continue
+		}
+
+		path := file.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(env.OutputDir, path)
+		}
+		validations = append(validations, executor.Validation{Path: path, Command: file.Validate})
+	}
+
+	if len(validations) == 0 {
+		return nil
+	}
+
+	failed := 0
+	for _, result := range executor.RunValidations(validations, validators) {
+		if result.Status == executor.ValidationFailed {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return utils.NewError(utils.ErrUnknown, fmt.Sprintf("validation failed for %d of %d rendered files", failed, len(validations)), nil)
+	}
+
+	logger.Info("Validated rendered files", "files", len(validations))
 	return nil
 }
 
 type Environment struct {
-	TempDir           string
+	TempDir           string // Private workspace under the system temp directory
+	OutputDir         string // Absolute directory receiving the rendered files
 	PlaybookPath      string
 	TempPlaybookPath  string
-	InventoryPath     string
+	InventoryPaths    []string // Inventory sources, relative to TempDir when generated
+	ExtraVars         []string // Variables passed to ansible-playbook with -e
+	Limit             string   // Host pattern passed with --limit, none when empty
 	AnsibleConfigPath string
 	OriginalDir       string
 }
 
 func setupEnvironment(playbookName string) (*Environment, error) {
-	tempDir := fmt.Sprintf("tmp-%s", playbookName)
-
-	if err := os.RemoveAll(tempDir); err != nil {
-		return nil, utils.NewError(utils.ErrUnknown, "cleaning existing directory", err)
-	}
-
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
+	// A private directory per run, so concurrent runs never collide
+	tempDir, err := os.MkdirTemp("", fmt.Sprintf("ansible-template-render-%s-", playbookName))
+	if err != nil {
 		return nil, utils.NewError(utils.ErrUnknown, "creating temp directory", err)
 	}
 
-	err := createRequiredDirectories(tempDir)
-	if err != nil {
+	if err := createRequiredDirectories(tempDir); err != nil {
+		os.RemoveAll(tempDir)
 		return nil, err
 	}
 
@@ -145,37 +536,47 @@ func createRequiredDirectories(tempDir string) error {
 		return utils.NewError(utils.ErrUnknown, "creating roles directory", err)
 	}
 
-	outputDir := filepath.Join(tempDir, "output")
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return utils.NewError(utils.ErrUnknown, "creating output directory", err)
-	}
-
 	return nil
 }
 
-func processPlaybookContent(playbookPath string, env *Environment, playbookName string) (bool, error) {
-	playbook, err := ansible.LoadPlaybook(playbookPath)
+func processPlaybookContent(playbookPath string, env *Environment, renderOpts ansible.RenderOptions) (bool, error) {
+	playbookPaths, err := ansible.ResolvePlaybookImports(playbookPath)
 	if err != nil {
-		return false, utils.NewError(utils.ErrUnknown, "loading playbook", err)
+		return false, utils.NewError(utils.ErrUnknown, "resolving playbook imports", err)
+	}
+	if len(playbookPaths) > 1 {
+		logger.Info("Found imported playbooks", "playbooks", playbookPaths[1:])
 	}
 
-	playbookCopier := &copier.PlaybookCopier{}
-	tempPlaybookPath, err := playbookCopier.CopyPlaybook(playbookPath, env.TempDir)
+	renderOpts.SearchPaths = discoverSearchPaths(playbookPaths)
+
+	tempPlaybookPaths, err := copyPlaybooks(playbookPaths, env)
 	if err != nil {
-		return false, utils.NewError(utils.ErrUnknown, "copying playbook", err)
+		return false, err
 	}
 	env.PlaybookPath = playbookPath
-	env.TempPlaybookPath = tempPlaybookPath
+	env.TempPlaybookPath = tempPlaybookPaths[0]
 
-	if err := createAnsibleConfig(env); err != nil {
+	if err := createAnsibleConfig(env, renderOpts.SearchPaths); err != nil {
 		return false, err
 	}
 
-	directRoles := ansible.ExtractRolesFromPlaybook(playbook)
+	var directRoles []string
+	for _, path := range playbookPaths {
+		playbook, err := ansible.LoadPlaybook(path)
+		if err != nil {
+			return false, utils.NewError(utils.ErrUnknown, "loading playbook", err)
+		}
+		if err := ansible.CheckPlaybookRoleIncludes(playbook, renderOpts.SearchPaths); err != nil {
+			return false, utils.NewConfigError(fmt.Sprintf("checking role includes in %s", path), err)
+		}
+		directRoles = append(directRoles, ansible.ExtractRolesFromPlaybook(playbook, renderOpts.SearchPaths)...)
+	}
+	directRoles = removeDuplicates(directRoles)
 	logger.Info("Found direct roles", "roles", directRoles)
 
 	resolvedRoles := make(map[string]bool)
-	allRoles, err := gatherAllRoles(directRoles, resolvedRoles)
+	allRoles, err := gatherAllRoles(directRoles, renderOpts.SearchPaths, resolvedRoles)
 	if err != nil {
 		return false, err
 	}
@@ -183,23 +584,85 @@ func processPlaybookContent(playbookPath string, env *Environment, playbookName
 	uniqueRoles := removeDuplicates(allRoles)
 	logger.Info("All roles (including dependencies)", "roles", uniqueRoles)
 
-	if err := copier.CopyAllRoles(uniqueRoles, env.TempDir); err != nil {
+	if err := copier.CopyAllRoles(uniqueRoles, env.TempDir, renderOpts.SearchPaths); err != nil {
 		return false, utils.NewError(utils.ErrUnknown, "copying roles", err)
 	}
 
-	hasTemplates, err := processor.ProcessAllRoles(uniqueRoles, env.TempDir, playbookName)
+	renderOpts.RenderedDirs = processor.CollectRenderedDirs(uniqueRoles, playbookPaths, renderOpts)
+
+	rolesHaveTemplates, err := processor.ProcessAllRoles(uniqueRoles, env.TempDir, renderOpts)
 	if err != nil {
 		return false, utils.NewError(utils.ErrUnknown, "processing role tasks", err)
 	}
 
-	return hasTemplates, nil
+	playsHaveTemplates, err := processor.ProcessAllPlaybooks(playbookPaths, tempPlaybookPaths, env.TempDir, renderOpts)
+	if err != nil {
+		return false, utils.NewError(utils.ErrUnknown, "processing play tasks", err)
+	}
+
+	return rolesHaveTemplates || playsHaveTemplates, nil
+}
+
+// Returns where roles and collections are looked up, based on Ansible's
+// configuration and the locations of the playbooks
+func discoverSearchPaths(playbookPaths []string) finder.SearchPaths {
+	playbookDirs := make([]string, 0, len(playbookPaths))
+	for _, path := range playbookPaths {
+		playbookDirs = append(playbookDirs, filepath.Dir(path))
+	}
+
+	searchPaths := finder.SearchPaths{
+		Roles:       finder.DiscoverRoleSearchPaths(playbookDirs),
+		Collections: finder.DiscoverCollectionSearchPaths(playbookDirs),
+	}
+	logger.Info("Role search paths", "paths", searchPaths.Roles)
+	logger.Info("Collection search paths", "paths", searchPaths.Collections)
+
+	return searchPaths
+}
+
+// Copies all playbooks into the temp directory, preserving their relative layout
+func copyPlaybooks(playbookPaths []string, env *Environment) ([]string, error) {
+	playbookDirs := make([]string, 0, len(playbookPaths))
+	for _, path := range playbookPaths {
+		playbookDirs = append(playbookDirs, filepath.Dir(path))
+	}
+	rootDir := utils.CommonDir(playbookDirs)
+
+	playbookCopier := &copier.PlaybookCopier{}
+	tempPaths := make([]string, 0, len(playbookPaths))
+	for _, path := range playbookPaths {
+		tempPath, err := playbookCopier.CopyPlaybookRelative(path, rootDir, env.TempDir)
+		if err != nil {
+			return nil, utils.NewError(utils.ErrUnknown, "copying playbook", err)
+		}
+		tempPaths = append(tempPaths, tempPath)
+
+		if err := playbookCopier.CopyPlaybookResources(path, rootDir, env.TempDir); err != nil {
+			return nil, utils.NewError(utils.ErrUnknown, "copying playbook resources", err)
+		}
+
+		playbook, err := ansible.LoadPlaybook(path)
+		if err != nil {
+			return nil, utils.NewError(utils.ErrUnknown, "loading playbook", err)
+		}
+		varsFiles := ansible.ExtractPlayVarsFiles(playbook)
+		if err := playbookCopier.CopyPlaybookVarsFiles(path, varsFiles, rootDir, env.TempDir); err != nil {
+			return nil, utils.NewError(utils.ErrUnknown, "copying playbook vars files", err)
+		}
+	}
+
+	return tempPaths, nil
 }
 
-func gatherAllRoles(directRoles []string, resolvedRoles map[string]bool) ([]string, error) {
+func gatherAllRoles(directRoles []string, searchPaths finder.SearchPaths, resolvedRoles map[string]bool) ([]string, error) {
 	var allRoles []string
 
 	for _, role := range directRoles {
-		roleList, err := ansible.ResolveRoleDependencies(role, resolvedRoles)
+		roleList, err := ansible.ResolveRoleDependencies(role, searchPaths, resolvedRoles)
+		if errors.Is(err, ansible.ErrRoleEntryNotFound) {
+			return nil, utils.NewConfigError(fmt.Sprintf("resolving dependencies of role %s", role), err)
+		}
 		if err != nil {
 			logger.Warn("Error resolving dependencies", "role", role, "error", err)
 			continue
@@ -210,13 +673,35 @@ func gatherAllRoles(directRoles []string, resolvedRoles map[string]bool) ([]stri
 	return allRoles, nil
 }
 
-func createAnsibleConfig(env *Environment) error {
+func createAnsibleConfig(env *Environment, searchPaths finder.SearchPaths) error {
 	ansibleCfgPath := filepath.Join(env.TempDir, "ansible.cfg")
 
-	ansibleCfgContent := `[defaults]
+	// Imported playbooks may live in subdirectories, so point roles_path
+	// at the copied roles explicitly
+	rolesPath, err := filepath.Abs(filepath.Join(env.TempDir, "roles"))
+	if err != nil {
+		return utils.NewError(utils.ErrUnknown, "resolving roles path", err)
+	}
+
+	// Copied collection roles take precedence over the installed collections,
+	// which stay available for plugins used by templates
+	collectionsDir, err := filepath.Abs(filepath.Join(env.TempDir, "collections"))
+	if err != nil {
+		return utils.NewError(utils.ErrUnknown, "resolving collections path", err)
+	}
+	collectionsPaths := []string{collectionsDir}
+	for _, path := range searchPaths.Collections {
+		if absPath, err := filepath.Abs(path); err == nil {
+			collectionsPaths = append(collectionsPaths, absPath)
+		}
+	}
+
+	ansibleCfgContent := fmt.Sprintf(`[defaults]
 retry_files_enabled = False
 local_tmp = ansible-tmp
-`
+roles_path = %s
+collections_path = %s
+`, rolesPath, strings.Join(collectionsPaths, string(os.PathListSeparator)))
 
 	if err := os.WriteFile(ansibleCfgPath, []byte(ansibleCfgContent), 0644); err != nil {
 		return utils.NewError(utils.ErrUnknown, "writing ansible.cfg file", err)
@@ -226,34 +711,59 @@ local_tmp = ansible-tmp
 	return nil
 }
 
-func printGenerateOnlyInstructions(env *Environment, ansibleArgs string) {
-	tempPlaybookBasename := filepath.Base(env.TempPlaybookPath)
+// Returns the directory Ansible records rendered files in
+func (e *Environment) RecordsDir() string {
+	return filepath.Join(e.TempDir, "records")
+}
+
+// Returns the directory holding the generated inventory and its vars
+func (e *Environment) InventoryDir() string {
+	return filepath.Join(e.TempDir, "inventory")
+}
+
+// Returns the playbook path relative to the temp directory
+func (e *Environment) relativePlaybookPath() string {
+	return e.relativePath(e.TempPlaybookPath)
+}
+
+// Returns a path inside the temp directory relative to it
+func (e *Environment) relativePath(path string) string {
+	relPath, err := filepath.Rel(e.TempDir, path)
+	if err != nil {
+		return filepath.Base(path)
+	}
+	return relPath
+}
+
+func printGenerateOnlyInstructions(env *Environment, ansibleArgs []string) {
 	absAnsibleCfgPath, _ := filepath.Abs(env.AnsibleConfigPath)
-	inventoryBasename := filepath.Base(env.InventoryPath)
 
 	logger.Info("Generated Ansible files in generate-only mode",
-		"playbook", tempPlaybookBasename,
-		"inventory", inventoryBasename,
+		"playbook", env.relativePlaybookPath(),
+		"inventory", env.InventoryPaths,
 		"dir", env.TempDir)
 
-	cmd := fmt.Sprintf("cd %s && ANSIBLE_CONFIG=%s ansible-playbook %s --tags render_config -i %s",
-		env.TempDir, absAnsibleCfgPath, tempPlaybookBasename, inventoryBasename)
-	if ansibleArgs != "" {
-		cmd += fmt.Sprintf(" %s", ansibleArgs)
-	}
+	args := executor.PlaybookArgs(env.executionEnvironment(ansibleArgs))
+	cmd := fmt.Sprintf("cd %s && ANSIBLE_CONFIG=%s ansible-playbook %s",
+		executor.ShellWord(env.TempDir), executor.ShellWord(absAnsibleCfgPath), executor.ShellJoin(args))
 	logger.Info("To execute manually:", "command", cmd)
 }
 
-func executeAnsible(env *Environment, ansibleArgs string) error {
-	execEnv := executor.ExecutionEnvironment{
-		WorkingDir:        env.TempDir,
-		PlaybookPath:      filepath.Base(env.TempPlaybookPath),
-		InventoryPath:     filepath.Base(env.InventoryPath),
-		AnsibleConfigPath: env.AnsibleConfigPath,
+func executeAnsible(env *Environment, ansibleArgs []string) error {
+	return executor.RunAnsible(env.executionEnvironment(ansibleArgs))
+}
+
+// Returns the settings running ansible-playbook in the workspace
+func (e *Environment) executionEnvironment(ansibleArgs []string) executor.ExecutionEnvironment {
+	return executor.ExecutionEnvironment{
+		WorkingDir:        e.TempDir,
+		PlaybookPath:      e.relativePlaybookPath(),
+		InventoryPaths:    e.InventoryPaths,
+		ExtraVars:         e.ExtraVars,
+		Limit:             e.Limit,
+		AnsibleConfigPath: e.AnsibleConfigPath,
 		AnsibleArgs:       ansibleArgs,
 	}
-
-	return executor.RunAnsible(execEnv)
 }
 
 func removeDuplicates(elements []string) []string {
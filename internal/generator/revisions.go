@@ -0,0 +1,134 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zinrai/ansible-template-render/internal/compare"
+	"github.com/zinrai/ansible-template-render/internal/executor"
+	"github.com/zinrai/ansible-template-render/internal/logger"
+	"github.com/zinrai/ansible-template-render/internal/manifest"
+	"github.com/zinrai/ansible-template-render/internal/utils"
+)
+
+// Holds the settings for comparing the rendered files of two git revisions
+type RevisionOptions struct {
+	Options             // Settings shared by both renders; OutputDir and CompareDir are not used
+	BaseRevision string // Revision the comparison starts from
+	HeadRevision string // Revision compared against the base
+}
+
+// Renders the playbook at two revisions of the git repository containing the
+// current directory and prints the differences between the rendered trees.
+// Returns ErrDifferences when they differ.
+func CompareRevisions(opts RevisionOptions) error {
+	originalDir, err := os.Getwd()
+	if err != nil {
+		return utils.NewError(utils.ErrUnknown, "getting current directory", err)
+	}
+
+	topLevel, prefix, err := executor.GitTopLevel(originalDir)
+	if err != nil {
+		return utils.NewError(utils.ErrExternalDependency, "finding git repository", err)
+	}
+
+	workDir, err := os.MkdirTemp("", "ansible-template-render-revisions-")
+	if err != nil {
+		return utils.NewError(utils.ErrUnknown, "creating temp directory", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	outputDirs := make([]string, 0, 2)
+	for _, revision := range []struct{ label, name string }{
+		{"base", opts.BaseRevision},
+		{"head", opts.HeadRevision},
+	} {
+		outputDir := filepath.Join(workDir, revision.label, "output")
+		if err := renderRevision(opts.Options, revision.name, topLevel, prefix, filepath.Join(workDir, revision.label, "tree"), outputDir); err != nil {
+			return utils.NewError(utils.ErrUnknown, fmt.Sprintf("rendering revision %s", revision.name), err)
+		}
+		outputDirs = append(outputDirs, outputDir)
+	}
+
+	result, err := compare.Trees(outputDirs[0], outputDirs[1], manifest.FileName)
+	if err != nil {
+		return utils.NewError(utils.ErrUnknown, "comparing rendered files", err)
+	}
+
+	if !result.HasDifferences() {
+		logger.Info("Rendered files match between revisions", "base", opts.BaseRevision, "head", opts.HeadRevision)
+		return nil
+	}
+
+	printComparison(result)
+	logger.Info("Rendered files differ between revisions",
+		"base", opts.BaseRevision,
+		"head", opts.HeadRevision,
+		"added", len(result.Added),
+		"removed", len(result.Removed),
+		"changed", len(result.Changed))
+	return ErrDifferences
+}
+
+// Checks out a revision into a worktree and renders it into outputDir,
+// running from the worktree's counterpart of the current directory
+func renderRevision(opts Options, revision, topLevel, prefix, treeDir, outputDir string) (err error) {
+	commit, err := executor.ResolveRevision(topLevel, revision)
+	if err != nil {
+		return utils.NewConfigError(fmt.Sprintf("unknown revision %s", revision), err)
+	}
+
+	if err := executor.AddWorktree(topLevel, treeDir, commit); err != nil {
+		return utils.NewError(utils.ErrExternalDependency, "adding worktree", err)
+	}
+	defer func() {
+		if removeErr := executor.RemoveWorktree(topLevel, treeDir); removeErr != nil {
+			logger.Warn("Failed to remove worktree", "path", treeDir, "error", removeErr)
+		}
+	}()
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		return utils.NewError(utils.ErrUnknown, "getting current directory", err)
+	}
+	if err := os.Chdir(filepath.Join(treeDir, filepath.FromSlash(prefix))); err != nil {
+		return utils.NewError(utils.ErrUnknown, "changing to worktree", err)
+	}
+	defer func() {
+		if chdirErr := os.Chdir(originalDir); chdirErr != nil && err == nil {
+			err = utils.NewError(utils.ErrUnknown, "changing back to original directory", chdirErr)
+		}
+	}()
+
+	// Relative paths now resolve inside the worktree; absolute paths into
+	// the repository are redirected there as well
+	opts.PlaybookPath = worktreePath(opts.PlaybookPath, topLevel, treeDir)
+	inventoryPaths := make([]string, 0, len(opts.InventoryPaths))
+	for _, inventoryPath := range opts.InventoryPaths {
+		inventoryPaths = append(inventoryPaths, worktreePath(inventoryPath, topLevel, treeDir))
+	}
+	opts.InventoryPaths = inventoryPaths
+	opts.BaselineDir = worktreePath(opts.BaselineDir, topLevel, treeDir)
+	opts.ConfigPath = worktreePath(opts.ConfigPath, topLevel, treeDir)
+	opts.OutputDir = outputDir
+	opts.CompareDir = ""
+	opts.GenerateOnly = false
+
+	logger.Info("Rendering revision", "revision", revision, "commit", commit)
+	return RunTemplateGeneration(opts)
+}
+
+// Maps an absolute path inside the repository to the same path in a worktree
+func worktreePath(path, topLevel, treeDir string) string {
+	if path == "" || !filepath.IsAbs(path) {
+		return path
+	}
+
+	relPath, err := filepath.Rel(topLevel, path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(treeDir, relPath)
+}
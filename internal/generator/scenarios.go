@@ -0,0 +1,169 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zinrai/ansible-template-render/internal/compare"
+	"github.com/zinrai/ansible-template-render/internal/copier"
+	"github.com/zinrai/ansible-template-render/internal/logger"
+	"github.com/zinrai/ansible-template-render/internal/manifest"
+	"github.com/zinrai/ansible-template-render/internal/scenario"
+	"github.com/zinrai/ansible-template-render/internal/utils"
+)
+
+// Name of the test suite in JUnit reports
+const scenarioSuiteName = "ansible-template-render"
+
+// Holds the settings for rendering scenarios and checking their output
+type ScenarioOptions struct {
+	Options             // Settings shared by all scenarios; a scenario's own settings take precedence
+	ScenariosDir string // Directory holding the scenarios
+	Update       bool   // Rewrite the expected output instead of comparing with it
+	JUnitPath    string // JUnit XML report, none when empty
+}
+
+// Renders every scenario and compares the output with its expected output.
+// Returns ErrDifferences when only differences were found, or an error when
+// a scenario could not be rendered.
+func RunScenarios(opts ScenarioOptions) error {
+	scenarios, err := scenario.Discover(opts.ScenariosDir)
+	if err != nil {
+		return utils.NewError(utils.ErrInvalidConfig, "loading scenarios", err)
+	}
+	if len(scenarios) == 0 {
+		return utils.NewConfigError(fmt.Sprintf("no scenarios found in %s (each needs a %s)", opts.ScenariosDir, scenario.FileName), nil)
+	}
+
+	workDir, err := os.MkdirTemp("", "ansible-template-render-scenarios-")
+	if err != nil {
+		return utils.NewError(utils.ErrUnknown, "creating temp directory", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	results := make([]scenario.Result, 0, len(scenarios))
+	failed, broken := 0, 0
+	for _, s := range scenarios {
+		result := runScenario(s, opts, filepath.Join(workDir, s.Name))
+		results = append(results, result)
+
+		switch result.Status {
+		case scenario.StatusFailed:
+			failed++
+			fmt.Print(result.Details)
+			logger.Error("Scenario failed", "name", s.Name, "reason", result.Message)
+		case scenario.StatusError:
+			broken++
+			logger.Error("Scenario could not be rendered", "name", s.Name, "error", result.Details)
+		case scenario.StatusUpdated:
+			logger.Info("Scenario updated", "name", s.Name, "expected", s.ExpectedDir())
+		default:
+			logger.Info("Scenario passed", "name", s.Name)
+		}
+	}
+
+	if opts.JUnitPath != "" {
+		if err := scenario.WriteJUnit(opts.JUnitPath, scenarioSuiteName, results); err != nil {
+			return utils.NewError(utils.ErrUnknown, "writing JUnit report", err)
+		}
+		logger.Info("JUnit report written", "path", opts.JUnitPath)
+	}
+
+	logger.Info("Scenarios finished", "total", len(results), "failed", failed, "errors", broken)
+
+	switch {
+	case broken > 0:
+		return utils.NewError(utils.ErrUnknown, fmt.Sprintf("%d of %d scenarios could not be rendered", broken, len(results)), nil)
+	case failed > 0:
+		return ErrDifferences
+	}
+	return nil
+}
+
+// Renders a scenario into outputDir and checks or updates its expected output
+func runScenario(s *scenario.Scenario, opts ScenarioOptions, outputDir string) scenario.Result {
+	start := time.Now()
+	result := scenario.Result{Name: s.Name}
+
+	runOpts := opts.Options
+	runOpts.PlaybookPath = s.PlaybookPath()
+	runOpts.InventoryPaths = s.InventoryPaths(opts.InventoryPaths)
+	runOpts.AnsibleArgs = append(append([]string(nil), s.Args...), opts.AnsibleArgs...)
+	runOpts.PerHost = opts.PerHost || s.PerHost
+	if s.Limit != "" {
+		runOpts.Limit = s.Limit
+	}
+	runOpts.OutputDir = outputDir
+	runOpts.CompareDir = ""
+	runOpts.GenerateOnly = false
+
+	logger.Info("Rendering scenario", "name", s.Name, "playbook", runOpts.PlaybookPath, "inventory", runOpts.InventoryPaths)
+	if err := RunTemplateGeneration(runOpts); err != nil {
+		result.Status = scenario.StatusError
+		result.Message = "rendering failed"
+		result.Details = err.Error()
+		return finishScenario(result, start)
+	}
+
+	if opts.Update {
+		if err := updateExpectedOutput(s.ExpectedDir(), outputDir); err != nil {
+			result.Status = scenario.StatusError
+			result.Message = "updating expected output failed"
+			result.Details = err.Error()
+			return finishScenario(result, start)
+		}
+		result.Status = scenario.StatusUpdated
+		return finishScenario(result, start)
+	}
+
+	if _, err := os.Stat(s.ExpectedDir()); err != nil {
+		result.Status = scenario.StatusError
+		result.Message = "no expected output"
+		result.Details = fmt.Sprintf("%s does not exist; run with -update to create it", s.ExpectedDir())
+		return finishScenario(result, start)
+	}
+
+	comparison, err := compare.Trees(s.ExpectedDir(), outputDir, manifest.FileName)
+	if err != nil {
+		result.Status = scenario.StatusError
+		result.Message = "comparing output failed"
+		result.Details = err.Error()
+		return finishScenario(result, start)
+	}
+
+	if comparison.HasDifferences() {
+		result.Status = scenario.StatusFailed
+		result.Message = fmt.Sprintf("rendered files differ from the expected output: %d added, %d removed, %d changed",
+			len(comparison.Added), len(comparison.Removed), len(comparison.Changed))
+		result.Details = comparison.Diff
+		return finishScenario(result, start)
+	}
+
+	result.Status = scenario.StatusPassed
+	return finishScenario(result, start)
+}
+
+// Sets how long a scenario took
+func finishScenario(result scenario.Result, start time.Time) scenario.Result {
+	result.Duration = time.Since(start)
+	return result
+}
+
+// Replaces the expected output with the rendered files, without the manifest
+func updateExpectedOutput(expectedDir, outputDir string) error {
+	if err := os.RemoveAll(expectedDir); err != nil {
+		return fmt.Errorf("removing expected output: %w", err)
+	}
+
+	if err := copier.CopyTree(outputDir, expectedDir); err != nil {
+		return fmt.Errorf("copying rendered files: %w", err)
+	}
+
+	if err := os.Remove(filepath.Join(expectedDir, manifest.FileName)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing manifest: %w", err)
+	}
+
+	return nil
+}
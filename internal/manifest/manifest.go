@@ -0,0 +1,327 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the manifest written to the output directory
+const FileName = "manifest.json"
+
+// Name of the file describing a recorded task inside its record directory
+const taskFileName = "task.json"
+
+// Describes a rewritten task whose rendered files are recorded at run time
+type TaskRecord struct {
+	ID       string `json:"id"`
+	Role     string `json:"role,omitempty"`
+	TaskFile string `json:"task_file"`
+	TaskName string `json:"task_name,omitempty"`
+	Src      string `json:"src,omitempty"`
+	Dest     string `json:"dest"` // Original destination, before redirection
+	Path     string `json:"path"` // Redirected destination, used when the result names none
+	Loop     bool   `json:"loop"`
+	Owner    string `json:"owner,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	Validate string `json:"validate,omitempty"` // validate command of the task
+}
+
+// Describes a single rendered file
+type RenderedFile struct {
+	Role     string      `json:"role,omitempty"`
+	TaskFile string      `json:"task_file"`
+	TaskName string      `json:"task_name,omitempty"`
+	Src      string      `json:"src,omitempty"`
+	Dest     string      `json:"dest"`           // Original destination, before redirection
+	Path     string      `json:"path"`           // Rendered file, relative to the output directory
+	Host     string      `json:"host"`           // Host whose variables rendered the file
+	Hosts    []string    `json:"hosts"`          // Hosts the file was rendered for
+	Item     interface{} `json:"item,omitempty"` // Loop item the file was rendered for
+	Owner    string      `json:"owner,omitempty"`
+	Group    string      `json:"group,omitempty"`
+	Mode     string      `json:"mode,omitempty"`
+	SHA256   string      `json:"sha256,omitempty"` // Checksum of the rendered content
+	Validate string      `json:"validate,omitempty"`
+}
+
+// Lists the files rendered by a run
+type Manifest struct {
+	Files []RenderedFile `json:"files"`
+}
+
+// Returns a stable identifier for a task at a position in a task file, such
+// as 2/block/0 for the first task in the block of the third task. The
+// position tells identical tasks in the same file apart.
+func TaskID(taskFile, position string, task map[string]interface{}) string {
+	data, _ := json.Marshal(task)
+	sum := sha256.Sum256(append([]byte(taskFile+"\n"+position+"\n"), data...))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Returns the directory holding the records of a task
+func RecordDir(recordsDir, id string) string {
+	return filepath.Join(recordsDir, id)
+}
+
+// Returns the file Ansible writes a host's registered task result to
+func HostResultPath(recordsDir, id string) string {
+	return filepath.Join(RecordDir(recordsDir, id), "{{ inventory_hostname }}.json")
+}
+
+// Writes the description of a recorded task
+func WriteTaskRecord(recordsDir string, record TaskRecord) error {
+	dir := RecordDir(recordsDir, record.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating record directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling task record: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, taskFileName), data, 0644); err != nil {
+		return fmt.Errorf("writing task record: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the Jinja expression of what a host writes to its result file:
+// the hosts the task ran for and the result registered in registerVar
+func HostResultContent(registerVar string, perHost bool) string {
+	// A task run once renders on behalf of every host in the play
+	hosts := "ansible_play_hosts"
+	if perHost {
+		hosts = "[inventory_hostname]"
+	}
+	return fmt.Sprintf("{{ {'hosts': %s, 'result': %s} | to_json }}", hosts, registerVar)
+}
+
+// Content of a host's result file
+type hostResult struct {
+	Hosts  []string   `json:"hosts"`
+	Result taskResult `json:"result"`
+}
+
+// Registered result of a rewritten task, for one host
+type taskResult struct {
+	Dest    string       `json:"dest"`
+	Skipped bool         `json:"skipped"`
+	Failed  bool         `json:"failed"`
+	Results []itemResult `json:"results"`
+}
+
+// Registered result of a rewritten task, for one loop item
+type itemResult struct {
+	Item    interface{} `json:"item"`
+	Dest    string      `json:"dest"`
+	Skipped bool        `json:"skipped"`
+	Failed  bool        `json:"failed"`
+}
+
+// Builds the manifest from the task records and the results Ansible wrote
+func Build(recordsDir, outputDir string) (*Manifest, error) {
+	entries, err := os.ReadDir(recordsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Manifest{Files: []RenderedFile{}}, nil
+		}
+		return nil, fmt.Errorf("reading records directory: %w", err)
+	}
+
+	files := []RenderedFile{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		taskFiles, err := readTaskRecord(filepath.Join(recordsDir, entry.Name()), outputDir)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, taskFiles...)
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Path != files[j].Path {
+			return files[i].Path < files[j].Path
+		}
+		return files[i].Host < files[j].Host
+	})
+
+	return &Manifest{Files: files}, nil
+}
+
+// Reads a task record and the per-host results written for it
+func readTaskRecord(dir, outputDir string) ([]RenderedFile, error) {
+	data, err := os.ReadFile(filepath.Join(dir, taskFileName))
+	if err != nil {
+		return nil, fmt.Errorf("reading task record: %w", err)
+	}
+
+	var record TaskRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("parsing task record %s: %w", dir, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading record directory: %w", err)
+	}
+
+	var files []RenderedFile
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == taskFileName || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		host := strings.TrimSuffix(entry.Name(), ".json")
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading task result: %w", err)
+		}
+
+		var hostData hostResult
+		if err := json.Unmarshal(data, &hostData); err != nil {
+			return nil, fmt.Errorf("parsing task result %s: %w", entry.Name(), err)
+		}
+		result := hostData.Result
+
+		hosts := hostData.Hosts
+		if len(hosts) == 0 {
+			hosts = []string{host}
+		}
+
+		newFile := func(dest string, item interface{}) RenderedFile {
+			return RenderedFile{
+				Role:     record.Role,
+				TaskFile: record.TaskFile,
+				TaskName: record.TaskName,
+				Src:      record.Src,
+				Dest:     record.Dest,
+				Path:     relativeToOutput(dest, outputDir),
+				Host:     host,
+				Hosts:    hosts,
+				Item:     item,
+				Owner:    record.Owner,
+				Group:    record.Group,
+				Mode:     record.Mode,
+				SHA256:   fileChecksum(dest, outputDir),
+				Validate: record.Validate,
+			}
+		}
+
+		if len(result.Results) == 0 {
+			if result.Skipped || result.Failed {
+				continue
+			}
+
+			// Modules editing a file in place do not report its path
+			dest := result.Dest
+			if dest == "" {
+				dest = hostPath(record.Path, host)
+			}
+			if dest != "" {
+				files = append(files, newFile(dest, nil))
+			}
+			continue
+		}
+
+		for _, item := range result.Results {
+			if item.Skipped || item.Failed || item.Dest == "" {
+				continue
+			}
+			files = append(files, newFile(item.Dest, item.Item))
+		}
+	}
+
+	return files, nil
+}
+
+// Returns the redirected destination of a task for a host, empty when it
+// depends on more than the host name
+func hostPath(path, host string) string {
+	path = strings.ReplaceAll(path, "{{ inventory_hostname }}", host)
+	if strings.Contains(path, "{{") {
+		return ""
+	}
+	return path
+}
+
+// Returns the SHA-256 checksum of a rendered file, empty when it cannot be read
+func fileChecksum(path, outputDir string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(outputDir, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns a rendered path relative to the output directory when it lies inside it
+func relativeToOutput(path, outputDir string) string {
+	relPath, err := filepath.Rel(outputDir, path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return relPath
+}
+
+// Saves the manifest as indented JSON
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling manifest: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	return nil
+}
+
+// Loads a manifest written by Save, failing for JSON that is not one: it
+// must hold nothing but the files list, and every file names its path and
+// destination
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	var m struct {
+		Files *[]RenderedFile `json:"files"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+	if m.Files == nil {
+		return nil, errors.New("parsing manifest: no files list")
+	}
+
+	for i, file := range *m.Files {
+		if file.Path == "" || file.Dest == "" {
+			return nil, fmt.Errorf("parsing manifest: file %d has no path or destination", i)
+		}
+	}
+
+	return &Manifest{Files: *m.Files}, nil
+}
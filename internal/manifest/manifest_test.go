@@ -0,0 +1,212 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuild(t *testing.T) {
+	recordsDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	record := TaskRecord{
+		ID:       "abc",
+		Role:     "nginx",
+		TaskFile: "roles/nginx/tasks/main.yml",
+		TaskName: "Configure vhosts",
+		Src:      "vhost.conf.j2",
+		Dest:     "/etc/nginx/sites/{{ item.name }}.conf",
+		Path:     filepath.Join(outputDir, "etc/nginx/sites/{{ item.name }}.conf"),
+		Loop:     true,
+		Owner:    "www-data",
+		Mode:     "0644",
+	}
+	if err := WriteTaskRecord(recordsDir, record); err != nil {
+		t.Fatalf("WriteTaskRecord() error = %v", err)
+	}
+
+	rendered := filepath.Join(outputDir, "etc/nginx/sites/a.conf")
+	if err := os.MkdirAll(filepath.Dir(rendered), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(rendered, []byte("server {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write rendered file: %v", err)
+	}
+
+	result := `{
+  "hosts": ["web1", "web2"],
+  "result": {
+    "changed": true,
+    "results": [
+      {"item": {"name": "a"}, "dest": "` + rendered + `"},
+      {"item": {"name": "b"}, "skipped": true},
+      {"item": {"name": "c"}, "dest": "` + filepath.Join(outputDir, "etc/nginx/sites/c.conf") + `"}
+    ]
+  }
+}`
+	if err := os.WriteFile(filepath.Join(RecordDir(recordsDir, "abc"), "web1.json"), []byte(result), 0644); err != nil {
+		t.Fatalf("Failed to write result: %v", err)
+	}
+
+	m, err := Build(recordsDir, outputDir)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	expected := []RenderedFile{
+		{
+			Role:     record.Role,
+			TaskFile: record.TaskFile,
+			TaskName: record.TaskName,
+			Src:      record.Src,
+			Dest:     record.Dest,
+			Path:     "etc/nginx/sites/a.conf",
+			Host:     "web1",
+			Hosts:    []string{"web1", "web2"},
+			Item:     map[string]interface{}{"name": "a"},
+			Owner:    record.Owner,
+			Mode:     record.Mode,
+			// sha256 of "server {}\n"
+			SHA256: "355da02c030cafce7f50bba6a64ec89983df42d6d1861cb9c9a8f38db15f674d",
+		},
+		{
+			Role:     record.Role,
+			TaskFile: record.TaskFile,
+			TaskName: record.TaskName,
+			Src:      record.Src,
+			Dest:     record.Dest,
+			Path:     "etc/nginx/sites/c.conf",
+			Host:     "web1",
+			Hosts:    []string{"web1", "web2"},
+			Item:     map[string]interface{}{"name": "c"},
+			Owner:    record.Owner,
+			Mode:     record.Mode,
+		},
+	}
+
+	if !reflect.DeepEqual(m.Files, expected) {
+		t.Errorf("Build() = %+v, want %+v", m.Files, expected)
+	}
+}
+
+func TestBuild_PathWithoutResultDest(t *testing.T) {
+	recordsDir := t.TempDir()
+
+	record := TaskRecord{
+		ID:       "def",
+		TaskFile: "roles/base/tasks/main.yml",
+		Dest:     "/etc/sudoers",
+		Path:     "/srv/out/{{ inventory_hostname }}/etc/sudoers",
+		Validate: "visudo -cf %s",
+	}
+	if err := WriteTaskRecord(recordsDir, record); err != nil {
+		t.Fatalf("WriteTaskRecord() error = %v", err)
+	}
+
+	// lineinfile and friends report no destination
+	result := `{"hosts": ["web1"], "result": {"changed": true}}`
+	if err := os.WriteFile(filepath.Join(RecordDir(recordsDir, "def"), "web1.json"), []byte(result), 0644); err != nil {
+		t.Fatalf("Failed to write result: %v", err)
+	}
+
+	m, err := Build(recordsDir, "/srv/out")
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	if len(m.Files) != 1 {
+		t.Fatalf("Build() returned %d files, want 1", len(m.Files))
+	}
+	if m.Files[0].Path != "web1/etc/sudoers" || m.Files[0].Validate != record.Validate {
+		t.Errorf("Build() = %+v", m.Files[0])
+	}
+}
+
+func TestBuild_NoRecords(t *testing.T) {
+	m, err := Build(filepath.Join(t.TempDir(), "missing"), "/srv/out")
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(m.Files) != 0 {
+		t.Errorf("Build() = %v, want no files", m.Files)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "saved manifest",
+			content: `{"files": [{"task_file": "main.yml", "dest": "/etc/motd", "path": "etc/motd", "host": "web1", "hosts": ["web1"]}]}`,
+		},
+		{
+			name:    "manifest without files",
+			content: `{"files": []}`,
+		},
+		{
+			name:    "unrelated manifest",
+			content: `{"name": "app", "version": "1.0.0"}`,
+			wantErr: true,
+		},
+		{
+			name:    "files list with unknown fields",
+			content: `{"files": [{"name": "app.js"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "file without path",
+			content: `{"files": [{"task_file": "main.yml", "dest": "/etc/motd"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "not JSON",
+			content: `files: []`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), FileName)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write manifest: %v", err)
+			}
+
+			_, err := Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTaskID(t *testing.T) {
+	task := map[string]interface{}{
+		"template": map[string]interface{}{"src": "motd.j2", "dest": "/etc/motd"},
+	}
+
+	id := TaskID("tasks/main.yml", "0", task)
+	if TaskID("tasks/main.yml", "0", task) != id {
+		t.Errorf("TaskID() is not stable")
+	}
+
+	// Identical tasks differ by file or position
+	others := []struct {
+		taskFile string
+		position string
+	}{
+		{"tasks/main.yml", "1"},
+		{"tasks/main.yml", "0/block/0"},
+		{"tasks/other.yml", "0"},
+	}
+	for _, other := range others {
+		if TaskID(other.taskFile, other.position, task) == id {
+			t.Errorf("TaskID(%q, %q) collides with the task at 0", other.taskFile, other.position)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"path/filepath"
+
+	"github.com/zinrai/ansible-template-render/internal/ansible"
+	"github.com/zinrai/ansible-template-render/internal/logger"
+	"github.com/zinrai/ansible-template-render/internal/utils"
+)
+
+// Processes a single assemble task joining rendered fragments
+func handleAssembleTask(task map[string]interface{}, processedDirs map[string]bool, taskFile string, opts ansible.RenderOptions) []map[string]interface{} {
+	var result []map[string]interface{}
+
+	assembleTask, _ := ansible.NewAssembleTask(task)
+	destPath := assembleTask.GetDestPath()
+
+	if dirTask := createDirectoryTaskForPath(destPath, processedDirs, opts); dirTask != nil {
+		result = append(result, dirTask)
+	}
+
+	modifiedTask, err := utils.DeepCopy(task)
+	if err != nil {
+		logger.Warn("Error copying task", "error", err)
+		modifiedTask = task
+	}
+	ansible.ModifyAssembleTask(modifiedTask.(map[string]interface{}), opts)
+	result = append(result, modifiedTask.(map[string]interface{}))
+
+	logger.Info("Modified assemble task", "file", taskFile, "src", assembleTask.GetSrcPath(), "dest", destPath)
+
+	return result
+}
+
+// Collects the directories that template tasks render into, across the
+// task files of all roles and the plays of all playbooks
+func CollectRenderedDirs(roles []string, playbookFiles []string, opts ansible.RenderOptions) map[string]bool {
+	renderedDirs := make(map[string]bool)
+
+	for _, roleName := range roles {
+		for _, taskFile := range findAllRoleTaskFiles(roleName, opts.SearchPaths) {
+			tasks, err := ansible.LoadTaskFile(taskFile)
+			if err != nil {
+				continue
+			}
+			addRenderedDirs(renderedDirs, tasks, opts)
+		}
+	}
+
+	for _, playbookFile := range playbookFiles {
+		plays, err := ansible.LoadPlaybook(playbookFile)
+		if err != nil {
+			continue
+		}
+
+		for _, play := range plays {
+			for _, section := range ansible.PlayTaskSections {
+				tasksList, ok := play[section].([]interface{})
+				if !ok {
+					continue
+				}
+
+				tasks, err := convertTasksList(tasksList)
+				if err != nil {
+					continue
+				}
+				addRenderedDirs(renderedDirs, tasks, opts)
+			}
+		}
+	}
+
+	return renderedDirs
+}
+
+// Adds the directories rendered into by a task list
+func addRenderedDirs(renderedDirs map[string]bool, tasks []map[string]interface{}, opts ansible.RenderOptions) {
+	for _, dir := range ansible.ExtractRenderedDirs(tasks, opts) {
+		renderedDirs[filepath.Clean(dir)] = true
+	}
+}
@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"fmt"
+
+	"github.com/zinrai/ansible-template-render/internal/ansible"
+	"github.com/zinrai/ansible-template-render/internal/logger"
+	"github.com/zinrai/ansible-template-render/internal/utils"
+)
+
+// Represents a task seeding a rendered file from the baseline directory
+type SeedTask struct {
+	DestPath string
+	Options  ansible.RenderOptions
+	Loop     map[string]interface{} // Iteration keywords of the edit task, so both run for the same items
+}
+
+// Creates a seed task running for the same items, and under the same
+// conditions, as the given edit task
+func NewSeedTask(destPath string, task map[string]interface{}, opts ansible.RenderOptions) *SeedTask {
+	return &SeedTask{
+		DestPath: destPath,
+		Options:  opts,
+		Loop:     ansible.IterationKeywords(task),
+	}
+}
+
+// Converts the seed task to a map representation
+func (s *SeedTask) ToMap() map[string]interface{} {
+	outputPath := s.Options.OutputPath(s.DestPath)
+	baselinePath := s.Options.BaselinePath(s.DestPath)
+
+	task := map[string]interface{}{
+		"name": fmt.Sprintf("Seed %s from baseline", outputPath),
+		"copy": map[string]interface{}{
+			"src":  baselinePath,
+			"dest": outputPath,
+			// Keep what earlier templates and edits wrote
+			"force": false,
+		},
+		"delegate_to": "localhost",
+		"tags":        []interface{}{"render_config"},
+	}
+
+	for key, value := range s.Loop {
+		task[key] = value
+	}
+
+	vars := make(map[string]interface{})
+	if taskVars, ok := s.Loop["vars"].(map[string]interface{}); ok {
+		for key, value := range taskVars {
+			vars[key] = value
+		}
+	}
+	vars["baseline_file"] = baselinePath
+	task["vars"] = vars
+
+	// Files missing from the baseline are left to the edit task's create option
+	var conditions []interface{}
+	switch when := s.Loop["when"].(type) {
+	case []interface{}:
+		conditions = append(conditions, when...)
+	case nil:
+	default:
+		conditions = append(conditions, when)
+	}
+	task["when"] = append(conditions, "baseline_file is file")
+
+	if !s.Options.PerHost {
+		task["run_once"] = true
+	}
+
+	return task
+}
+
+// Processes a single edit task, seeding the edited file from the baseline
+func handleEditTask(task map[string]interface{}, processedDirs map[string]bool, taskFile string, opts ansible.RenderOptions) []map[string]interface{} {
+	var result []map[string]interface{}
+
+	editTask, _ := ansible.NewEditTask(task)
+	destPath := editTask.GetDestPath()
+
+	var dirTask map[string]interface{}
+	if hasVaryingDest(task, destPath) {
+		dirTask = NewLoopDirectoryTask(destPath, task, opts).ToMap()
+	} else {
+		dirTask = createDirectoryTaskForPath(destPath, processedDirs, opts)
+	}
+	if dirTask != nil {
+		result = append(result, dirTask)
+	}
+	result = append(result, NewSeedTask(destPath, task, opts).ToMap())
+
+	modifiedTask, err := utils.DeepCopy(task)
+	if err != nil {
+		logger.Warn("Error copying task", "error", err)
+		modifiedTask = task
+	}
+	ansible.ModifyEditTask(modifiedTask.(map[string]interface{}), opts)
+	result = append(result, modifiedTask.(map[string]interface{}))
+
+	logger.Info("Modified edit task", "file", taskFile, "path", destPath)
+
+	return result
+}
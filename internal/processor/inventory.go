@@ -9,11 +9,40 @@ import (
 	"github.com/goccy/go-yaml"
 )
 
-// ModifyInventoryForLocalExecution converts any inventory (INI, YAML, dynamic)
-// to a static YAML inventory with ansible_connection=local for all hosts
-func ModifyInventoryForLocalExecution(inventoryPath, destDir string) (string, error) {
+// Ways of pointing ansible-playbook at the inventory for local execution
+const (
+	InventoryFlatten = "flatten" // Static copy from ansible-inventory, with ansible_connection on every host
+	InventoryKeep    = "keep"    // Original sources, with the connection overridden by an extra var
+)
+
+// Extra var making every host use the local connection. Extra vars take
+// precedence over inventory and vars files alike, so nothing else changes.
+const LocalConnectionVar = "ansible_connection=local"
+
+// KeepInventoryForLocalExecution returns the original inventory sources as
+// absolute paths, so ansible-playbook reads them from the workspace with their
+// group hierarchy, inventory vars and adjacent group_vars/host_vars intact.
+// The connection is made local with LocalConnectionVar.
+func KeepInventoryForLocalExecution(inventoryPaths []string) ([]string, error) {
+	sources := make([]string, 0, len(inventoryPaths))
+	for _, inventoryPath := range inventoryPaths {
+		absPath, err := filepath.Abs(inventoryPath)
+		if err != nil {
+			return nil, fmt.Errorf("resolving inventory path %s: %w", inventoryPath, err)
+		}
+		sources = append(sources, absPath)
+	}
+	return sources, nil
+}
+
+// ModifyInventoryForLocalExecution converts any inventory sources (INI, YAML,
+// dynamic, or directories of them) to a static YAML inventory with
+// ansible_connection=local for all hosts. Every host is kept: a limit is
+// applied by ansible-playbook, and like a real limited run, templates still
+// see every host in groups and hostvars.
+func ModifyInventoryForLocalExecution(inventoryPaths []string, destDir string) (string, error) {
 	// 1. Run ansible-inventory to get YAML output
-	output, err := runAnsibleInventory(inventoryPath)
+	output, err := runAnsibleInventory(inventoryPaths)
 	if err != nil {
 		return "", fmt.Errorf("running ansible-inventory: %w", err)
 	}
@@ -28,6 +57,9 @@ func ModifyInventoryForLocalExecution(inventoryPath, destDir string) (string, er
 	injectLocalConnection(inventory)
 
 	// 4. Write YAML file
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return "", fmt.Errorf("creating inventory directory: %w", err)
+	}
 	destPath := filepath.Join(destDir, "inventory.yaml")
 	data, err := yaml.Marshal(inventory)
 	if err != nil {
@@ -40,9 +72,16 @@ func ModifyInventoryForLocalExecution(inventoryPath, destDir string) (string, er
 	return destPath, nil
 }
 
-// runAnsibleInventory executes ansible-inventory command and returns YAML output
-func runAnsibleInventory(inventoryPath string) ([]byte, error) {
-	cmd := exec.Command("ansible-inventory", "-i", inventoryPath, "--list", "--yaml")
+// runAnsibleInventory executes ansible-inventory command over all inventory
+// sources and returns YAML output
+func runAnsibleInventory(inventoryPaths []string) ([]byte, error) {
+	args := make([]string, 0, 2*len(inventoryPaths)+2)
+	for _, inventoryPath := range inventoryPaths {
+		args = append(args, "-i", inventoryPath)
+	}
+	args = append(args, "--list", "--yaml")
+
+	cmd := exec.Command("ansible-inventory", args...)
 	output, err := cmd.Output()
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -1,6 +1,11 @@
 package processor
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 )
 
@@ -38,3 +43,131 @@ func TestInjectLocalConnection_NestedChildren(t *testing.T) {
 		}
 	}
 }
+
+func TestKeepInventoryForLocalExecution_KeepsSources(t *testing.T) {
+	projectDir := t.TempDir()
+	t.Chdir(projectDir)
+
+	hostsIni := "[web]\nweb1 ansible_host=192.168.2.7 http_port=8080\n\n[prod:children]\nweb\n\n[prod:vars]\nhttp_port=80\n"
+	groupVars := "http_port: 8000\n"
+	files := map[string]string{
+		"inventories/prod/hosts.ini":           hostsIni,
+		"inventories/prod/group_vars/prod.yml": groupVars,
+		"inventories/extra/hosts.yml":          "all:\n  hosts:\n    db1:\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	sources, err := KeepInventoryForLocalExecution([]string{
+		"inventories/prod",
+		filepath.Join(projectDir, "inventories/extra/hosts.yml"),
+	})
+	if err != nil {
+		t.Fatalf("KeepInventoryForLocalExecution() error = %v", err)
+	}
+
+	// The original sources are used in order, so Ansible resolves the group
+	// hierarchy, inventory vars and group_vars exactly as it would without us
+	expected := []string{
+		filepath.Join(projectDir, "inventories/prod"),
+		filepath.Join(projectDir, "inventories/extra/hosts.yml"),
+	}
+	if len(sources) != len(expected) {
+		t.Fatalf("Expected %d sources, got %d: %v", len(expected), len(sources), sources)
+	}
+	for i, want := range expected {
+		if sources[i] != want {
+			t.Errorf("sources[%d] = %q, want %q", i, sources[i], want)
+		}
+	}
+
+	for path, content := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if string(data) != content {
+			t.Errorf("%s was modified:\n%s", path, data)
+		}
+	}
+}
+
+func TestKeepInventoryForLocalExecution_VariablePrecedence(t *testing.T) {
+	if _, err := exec.LookPath("ansible-inventory"); err != nil {
+		t.Skip("ansible-inventory is not installed")
+	}
+
+	projectDir := t.TempDir()
+	t.Chdir(projectDir)
+
+	// web1 sets its own port; web2 gets it from its group, where the
+	// playbook's group_vars take precedence over the inventory's and over
+	// the [prod:vars] section
+	files := map[string]string{
+		"inventories/prod/hosts.ini":           "[web]\nweb1 http_port=8080\nweb2\n\n[prod:children]\nweb\n\n[prod:vars]\nhttp_port=80\n",
+		"inventories/prod/group_vars/prod.yml": "http_port: 8000\n",
+		"group_vars/prod.yml":                  "http_port: 9000\n",
+		"site.yml":                             "- hosts: all\n  tasks: []\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	sources, err := KeepInventoryForLocalExecution([]string{"inventories/prod"})
+	if err != nil {
+		t.Fatalf("KeepInventoryForLocalExecution() error = %v", err)
+	}
+
+	for host, want := range map[string]string{"web1": "8080", "web2": "9000"} {
+		if got := resolveHostVar(t, sources, projectDir, host, "http_port"); got != want {
+			t.Errorf("keep mode: %s http_port = %s, want %s", host, got, want)
+		}
+	}
+
+	// Flattening turns the group's value into a host var, which then
+	// overrides the playbook's group_vars
+	flattened, err := ModifyInventoryForLocalExecution([]string{"inventories/prod"}, filepath.Join(projectDir, "flattened"))
+	if err != nil {
+		t.Fatalf("ModifyInventoryForLocalExecution() error = %v", err)
+	}
+	if got := resolveHostVar(t, []string{flattened}, projectDir, "web1", "http_port"); got != "8080" {
+		t.Errorf("flatten mode: web1 http_port = %s, want 8080", got)
+	}
+	if got := resolveHostVar(t, []string{flattened}, projectDir, "web2", "http_port"); got == "9000" {
+		t.Errorf("flatten mode: web2 http_port = %s, want the inventory's value baked in", got)
+	}
+}
+
+// Returns a variable of a host as ansible-playbook would see it, with the
+// local connection passed as an extra var
+func resolveHostVar(t *testing.T, inventoryPaths []string, playbookDir, host, name string) string {
+	t.Helper()
+
+	var args []string
+	for _, inventoryPath := range inventoryPaths {
+		args = append(args, "-i", inventoryPath)
+	}
+	args = append(args, "--playbook-dir", playbookDir, "-e", LocalConnectionVar, "--host", host)
+
+	output, err := exec.Command("ansible-inventory", args...).Output()
+	if err != nil {
+		t.Fatalf("ansible-inventory %v failed: %v", args, err)
+	}
+
+	var vars map[string]interface{}
+	if err := json.Unmarshal(output, &vars); err != nil {
+		t.Fatalf("Failed to parse host vars: %v", err)
+	}
+	return fmt.Sprint(vars[name])
+}
@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// LimitInventoryHosts returns the hosts of the inventory sources matching a
+// host pattern, such as "webservers:&eu-west:!canary", as Ansible selects
+// them for --limit
+func LimitInventoryHosts(inventoryPaths []string, limit string) ([]string, error) {
+	output, err := runAnsibleListHosts(inventoryPaths, limit)
+	if err != nil {
+		return nil, fmt.Errorf("running ansible --list-hosts: %w", err)
+	}
+	return parseListHosts(output), nil
+}
+
+// runAnsibleListHosts executes ansible with --list-hosts, which prints the
+// hosts matching the pattern without connecting to them
+func runAnsibleListHosts(inventoryPaths []string, pattern string) ([]byte, error) {
+	args := make([]string, 0, 2*len(inventoryPaths)+2)
+	args = append(args, pattern)
+	for _, inventoryPath := range inventoryPaths {
+		args = append(args, "-i", inventoryPath)
+	}
+	args = append(args, "--list-hosts")
+
+	cmd := exec.Command("ansible", args...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ansible failed: %s", string(exitErr.Stderr))
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+// parseListHosts returns the hosts listed under the "hosts (N):" header
+// printed by ansible --list-hosts
+func parseListHosts(output []byte) []string {
+	var hosts []string
+	inList := false
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "hosts (") && strings.HasSuffix(line, "):") {
+			inList = true
+			continue
+		}
+		if inList {
+			hosts = append(hosts, line)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,62 @@
+package processor
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseListHosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{
+			name:   "matching hosts",
+			output: "  hosts (2):\n    web1\n    web2\n",
+			want:   []string{"web1", "web2"},
+		},
+		{
+			name:   "no matching hosts",
+			output: "  hosts (0):\n",
+			want:   nil,
+		},
+		{
+			name:   "output before the list is ignored",
+			output: "Using /etc/ansible/ansible.cfg as config file\n  hosts (1):\n    db1\n",
+			want:   []string{"db1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseListHosts([]byte(tt.output)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseListHosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitInventoryHosts(t *testing.T) {
+	if _, err := exec.LookPath("ansible"); err != nil {
+		t.Skip("ansible is not installed")
+	}
+
+	projectDir := t.TempDir()
+	inventoryPath := filepath.Join(projectDir, "hosts.ini")
+	inventory := "[eu-west]\nweb1\nweb2\ncanary1\n\n[us-east]\nweb3\n\n[webservers:children]\neu-west\nus-east\n\n[canary]\ncanary1\n\n[dbservers]\ndb1\n"
+	if err := os.WriteFile(inventoryPath, []byte(inventory), 0644); err != nil {
+		t.Fatalf("Failed to write inventory: %v", err)
+	}
+
+	got, err := LimitInventoryHosts([]string{inventoryPath}, "webservers:&eu-west:!canary")
+	if err != nil {
+		t.Fatalf("LimitInventoryHosts() error = %v", err)
+	}
+	if want := []string{"web1", "web2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LimitInventoryHosts() = %v, want %v", got, want)
+	}
+}
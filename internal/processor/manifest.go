@@ -0,0 +1,137 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zinrai/ansible-template-render/internal/ansible"
+	"github.com/zinrai/ansible-template-render/internal/logger"
+	"github.com/zinrai/ansible-template-render/internal/manifest"
+)
+
+// Variable receiving the result of a recorded task that registers none itself
+const recordRegisterVar = "render_config_result"
+
+// Represents a task writing a host's rendered files to the records directory
+type RecordTask struct {
+	ID          string
+	RegisterVar string
+	DestPath    string
+	Options     ansible.RenderOptions
+}
+
+// Converts the record task to a map representation
+func (r *RecordTask) ToMap() map[string]interface{} {
+	task := map[string]interface{}{
+		"name": fmt.Sprintf("Record rendered files for %s", r.Options.OutputPath(r.DestPath)),
+		"copy": map[string]interface{}{
+			"content": manifest.HostResultContent(r.RegisterVar, r.Options.PerHost),
+			"dest":    manifest.HostResultPath(r.Options.RecordsDir, r.ID),
+		},
+		"when":        fmt.Sprintf("%s is defined", r.RegisterVar),
+		"delegate_to": "localhost",
+		"tags":        []interface{}{"render_config"},
+	}
+
+	if !r.Options.PerHost {
+		task["run_once"] = true
+	}
+
+	return task
+}
+
+// Records the files a rewritten task writes: template-like, edit and
+// assemble tasks. Registers the result of the modified task and returns the
+// task writing it out, or nil when the task cannot be recorded.
+func createRecordTaskIfNeeded(task, modifiedTask map[string]interface{}, taskFile, position string, opts ansible.RenderOptions) map[string]interface{} {
+	if opts.RecordsDir == "" {
+		return nil
+	}
+
+	record, ok := newTaskRecord(task, taskFile, position, opts)
+	if !ok {
+		return nil
+	}
+
+	if err := manifest.WriteTaskRecord(opts.RecordsDir, record); err != nil {
+		logger.Warn("Cannot record rendered files", "file", taskFile, "dest", record.Dest, "error", err)
+		return nil
+	}
+
+	// Reuse the task's own variable so later tasks still see their result
+	registerVar, ok := modifiedTask["register"].(string)
+	if !ok || registerVar == "" {
+		registerVar = recordRegisterVar
+		modifiedTask["register"] = registerVar
+	}
+
+	recordTask := &RecordTask{
+		ID:          record.ID,
+		RegisterVar: registerVar,
+		DestPath:    record.Dest,
+		Options:     opts,
+	}
+	return recordTask.ToMap()
+}
+
+// Describes a rewritten task from its original, unmodified form
+func newTaskRecord(task map[string]interface{}, taskFile, position string, opts ansible.RenderOptions) (manifest.TaskRecord, bool) {
+	var moduleData map[string]interface{}
+	var destPath, srcPath, validate string
+
+	if templateTask, ok := ansible.NewTemplateTask(task, opts); ok {
+		moduleData = templateTask.ModuleData
+		destPath = templateTask.GetDestPath()
+		srcPath, _ = moduleData["src"].(string)
+		validate = templateTask.GetValidateCommand()
+	} else if assembleTask, ok := ansible.NewAssembleTask(task); ok {
+		moduleData = assembleTask.ModuleData
+		destPath = assembleTask.GetDestPath()
+		srcPath = assembleTask.GetSrcPath()
+		validate = assembleTask.GetValidateCommand()
+	} else if editTask, ok := ansible.NewEditTask(task); ok {
+		moduleData = editTask.ModuleData
+		destPath = editTask.GetDestPath()
+		validate = editTask.GetValidateCommand()
+	}
+
+	if destPath == "" {
+		return manifest.TaskRecord{}, false
+	}
+
+	record := manifest.TaskRecord{
+		ID:       manifest.TaskID(taskFile, position, task),
+		Role:     opts.Role,
+		TaskFile: relativeTaskFile(taskFile),
+		Src:      srcPath,
+		Dest:     destPath,
+		Path:     opts.OutputPath(destPath),
+		Loop:     ansible.IsLoopTask(task),
+		Validate: validate,
+	}
+	record.TaskName, _ = task["name"].(string)
+	record.Owner, record.Group, record.Mode = ansible.FileAttributes(moduleData)
+
+	return record, true
+}
+
+// Returns a task file relative to the current directory when it lies inside
+// it, so that manifests do not depend on where the project is checked out
+func relativeTaskFile(taskFile string) string {
+	if !filepath.IsAbs(taskFile) {
+		return taskFile
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return taskFile
+	}
+
+	relPath, err := filepath.Rel(wd, taskFile)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return taskFile
+	}
+	return relPath
+}
@@ -0,0 +1,187 @@
+package processor
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/zinrai/ansible-template-render/internal/ansible"
+	"github.com/zinrai/ansible-template-render/internal/logger"
+	"github.com/zinrai/ansible-template-render/internal/utils"
+)
+
+// Processes tasks written directly in plays
+type PlaybookProcessor struct{}
+
+// Represents the result of processing the plays of a playbook
+type PlaybookResult struct {
+	Plays        []map[string]interface{}
+	Origins      []ansible.TaskOrigin // Edited sections of each play, parallel to Plays
+	Modified     bool
+	HasTemplates bool
+}
+
+// Processes template tasks in every task section of every play
+func ProcessPlaybookPlays(plays []map[string]interface{}, playbookFile string, opts ansible.RenderOptions) PlaybookResult {
+	result := make([]map[string]interface{}, 0, len(plays))
+	origins := make([]ansible.TaskOrigin, 0, len(plays))
+	modified := false
+	hasTemplates := false
+
+	for i, play := range plays {
+		playCopy := make(map[string]interface{}, len(play))
+		for key, value := range play {
+			playCopy[key] = value
+		}
+
+		sections := make(map[string]ansible.TaskListEdit)
+
+		for _, section := range ansible.PlayTaskSections {
+			tasksList, ok := play[section].([]interface{})
+			if !ok {
+				continue
+			}
+
+			tasks, err := convertTasksList(tasksList)
+			if err != nil {
+				logger.Warn("Skipping play section", "file", playbookFile, "section", section, "error", err)
+				continue
+			}
+
+			// Each play and section gets its own directories and task positions
+			sectionResult := processTaskList(tasks, make(map[string]bool), playbookFile, fmt.Sprintf("%d/%s", i, section), opts)
+			if !sectionResult.Modified {
+				continue
+			}
+
+			playCopy[section] = toInterfaceList(sectionResult.Tasks)
+			sections[section] = sectionResult.Edit()
+			modified = true
+			if sectionResult.HasTemplates {
+				hasTemplates = true
+			}
+		}
+
+		result = append(result, playCopy)
+		origins = append(origins, ansible.TaskOrigin{Index: i, Sections: sections})
+	}
+
+	return PlaybookResult{
+		Plays:        result,
+		Origins:      origins,
+		Modified:     modified,
+		HasTemplates: hasTemplates,
+	}
+}
+
+// Processes a single playbook, writing the result over its copy in the temp directory
+func (p *PlaybookProcessor) ProcessPlaybookFile(playbookFile, tempPlaybookFile string, opts ansible.RenderOptions) (bool, error) {
+	plays, err := ansible.LoadPlaybook(playbookFile)
+	if err != nil {
+		return false, fmt.Errorf("loading playbook %s: %w", playbookFile, err)
+	}
+
+	result := ProcessPlaybookPlays(plays, playbookFile, opts)
+
+	// No modifications needed, the verbatim copy is kept
+	if !result.Modified {
+		return result.HasTemplates, nil
+	}
+
+	edit := ansible.TaskListEdit{Tasks: result.Plays, Origins: result.Origins}
+	if err := ansible.SavePlaybookFrom(playbookFile, edit, tempPlaybookFile); err != nil {
+		return false, fmt.Errorf("saving modified playbook: %w", err)
+	}
+
+	return result.HasTemplates, nil
+}
+
+// Processes the task files included or imported by the plays of a playbook,
+// writing them next to its copy in the temp directory
+func (p *PlaybookProcessor) ProcessPlayTaskFiles(playbookFile, tempPlaybookFile, tempDir string, opts ansible.RenderOptions) (bool, error) {
+	plays, err := ansible.LoadPlaybook(playbookFile)
+	if err != nil {
+		return false, fmt.Errorf("loading playbook %s: %w", playbookFile, err)
+	}
+
+	// Play includes are relative to the playbook, as are the includes of
+	// the files they pull in when not found next to the including file
+	playbookDir := filepath.Dir(playbookFile)
+	taskFiles := ansible.ResolveTaskIncludes(ansible.ExtractPlayTaskIncludes(plays), playbookFile, []string{playbookDir})
+
+	taskProcessor := &TaskProcessor{}
+	hasTemplates := false
+
+	for _, taskFile := range ansible.FollowTaskIncludes(taskFiles, playbookDir) {
+		relPath, err := filepath.Rel(playbookDir, taskFile)
+		if err != nil {
+			return false, fmt.Errorf("getting relative task file path: %w", err)
+		}
+
+		tempTaskFile := filepath.Join(filepath.Dir(tempPlaybookFile), relPath)
+		if !utils.IsWithinDir(tempTaskFile, tempDir) {
+			logger.Warn("Skipping task file outside the playbook tree", "playbook", playbookFile, "file", taskFile)
+			continue
+		}
+
+		fileHasTemplates, err := taskProcessor.processTaskFileTo(taskFile, tempTaskFile, opts)
+		if err != nil {
+			return false, err
+		}
+
+		if fileHasTemplates {
+			hasTemplates = true
+		}
+	}
+
+	return hasTemplates, nil
+}
+
+// Processes play tasks, and the task files they include, for all playbooks
+func ProcessAllPlaybooks(playbookFiles, tempPlaybookFiles []string, tempDir string, opts ansible.RenderOptions) (bool, error) {
+	processor := &PlaybookProcessor{}
+	hasTemplates := false
+
+	for i, playbookFile := range playbookFiles {
+		logger.Info("Processing play tasks", "playbook", playbookFile)
+
+		playbookHasTemplates, err := processor.ProcessPlaybookFile(playbookFile, tempPlaybookFiles[i], opts)
+		if err != nil {
+			return false, err
+		}
+
+		includesHaveTemplates, err := processor.ProcessPlayTaskFiles(playbookFile, tempPlaybookFiles[i], tempDir, opts)
+		if err != nil {
+			return false, err
+		}
+
+		if playbookHasTemplates || includesHaveTemplates {
+			hasTemplates = true
+		}
+	}
+
+	return hasTemplates, nil
+}
+
+// Converts a list of interface{} to task maps
+func convertTasksList(tasksList []interface{}) ([]map[string]interface{}, error) {
+	tasks := make([]map[string]interface{}, 0, len(tasksList))
+
+	for i, taskItem := range tasksList {
+		taskMap, ok := taskItem.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("task %d is not a mapping", i)
+		}
+		tasks = append(tasks, taskMap)
+	}
+
+	return tasks, nil
+}
+
+// Converts task maps back to a list of interface{}
+func toInterfaceList(tasks []map[string]interface{}) []interface{} {
+	list := make([]interface{}, 0, len(tasks))
+	for _, task := range tasks {
+		list = append(list, task)
+	}
+	return list
+}
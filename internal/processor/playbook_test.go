@@ -0,0 +1,166 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/ansible-template-render/internal/ansible"
+)
+
+func TestProcessPlaybookPlays(t *testing.T) {
+	plays := []map[string]interface{}{
+		{"import_playbook": "other.yml"},
+		{
+			"hosts": "webservers",
+			"pre_tasks": []interface{}{
+				map[string]interface{}{
+					"name":    "Update cache",
+					"command": "apt-get update",
+				},
+			},
+			"tasks": []interface{}{
+				map[string]interface{}{
+					"name": "Configure app",
+					"template": map[string]interface{}{
+						"src":  "app.conf.j2",
+						"dest": "/etc/app/app.conf",
+					},
+					"notify": "restart app",
+				},
+			},
+			"handlers": []interface{}{
+				map[string]interface{}{
+					"name": "restart app",
+					"ansible.builtin.template": map[string]interface{}{
+						"src":  "reload.j2",
+						"dest": "/etc/app/reload",
+					},
+				},
+			},
+		},
+	}
+
+	result := ProcessPlaybookPlays(plays, "site.yml", ansible.RenderOptions{PlaybookName: "site"})
+
+	if !result.Modified || !result.HasTemplates {
+		t.Fatalf("ProcessPlaybookPlays() Modified = %v, HasTemplates = %v, want true, true",
+			result.Modified, result.HasTemplates)
+	}
+
+	if len(result.Plays) != len(plays) {
+		t.Fatalf("ProcessPlaybookPlays() returned %d plays, want %d", len(result.Plays), len(plays))
+	}
+
+	play := result.Plays[1]
+
+	// Non-template section is left untouched
+	preTasks := play["pre_tasks"].([]interface{})
+	if len(preTasks) != 1 {
+		t.Errorf("pre_tasks has %d tasks, want 1", len(preTasks))
+	}
+
+	for _, section := range []string{"tasks", "handlers"} {
+		tasks := play[section].([]interface{})
+		if len(tasks) != 2 {
+			t.Errorf("%s has %d tasks, want 2 (directory task + template task)", section, len(tasks))
+			continue
+		}
+
+		templateTask, ok := ansible.NewTemplateTask(tasks[1].(map[string]interface{}), ansible.RenderOptions{})
+		if !ok {
+			t.Errorf("%s: last task is not a template task", section)
+			continue
+		}
+
+		if !strings.HasPrefix(templateTask.GetDestPath(), "output/") {
+			t.Errorf("%s: template task not modified correctly: %v", section, templateTask.GetDestPath())
+		}
+	}
+
+	// The original plays are not modified
+	originalTasks := plays[1]["tasks"].([]interface{})
+	if len(originalTasks) != 1 {
+		t.Errorf("original tasks were modified: %v", originalTasks)
+	}
+}
+
+func TestProcessPlaybookPlays_NoTemplates(t *testing.T) {
+	plays := []map[string]interface{}{
+		{
+			"hosts": "all",
+			"roles": []interface{}{"common"},
+			"tasks": []interface{}{
+				map[string]interface{}{
+					"command": "echo hello",
+				},
+			},
+		},
+	}
+
+	result := ProcessPlaybookPlays(plays, "site.yml", ansible.RenderOptions{PlaybookName: "site"})
+
+	if result.Modified || result.HasTemplates {
+		t.Errorf("ProcessPlaybookPlays() Modified = %v, HasTemplates = %v, want false, false",
+			result.Modified, result.HasTemplates)
+	}
+}
+
+func TestPlaybookProcessor_ProcessPlayTaskFiles(t *testing.T) {
+	projectDir := t.TempDir()
+	tempDir := t.TempDir()
+
+	files := map[string]string{
+		"site.yml": `---
+- hosts: all
+  tasks:
+    - include_tasks: tasks/app.yml
+`,
+		"tasks/app.yml": `---
+- name: Configure app
+  template:
+    src: app.conf.j2
+    dest: /etc/app/app.conf
+- import_tasks: common.yml
+`,
+		"tasks/common.yml": `---
+- name: Install package
+  package:
+    name: app
+`,
+	}
+	for path, content := range files {
+		fullPath := filepath.Join(projectDir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	processor := &PlaybookProcessor{}
+	hasTemplates, err := processor.ProcessPlayTaskFiles(filepath.Join(projectDir, "site.yml"),
+		filepath.Join(tempDir, "site.yml"), tempDir, ansible.RenderOptions{PlaybookName: "site"})
+	if err != nil {
+		t.Fatalf("ProcessPlayTaskFiles() error = %v", err)
+	}
+	if !hasTemplates {
+		t.Errorf("ProcessPlayTaskFiles() did not find the template in the included task file")
+	}
+
+	tasks, err := ansible.LoadTaskFile(filepath.Join(tempDir, "tasks", "app.yml"))
+	if err != nil {
+		t.Fatalf("included task file was not written: %v", err)
+	}
+	templateTask, ok := ansible.NewTemplateTask(tasks[1], ansible.RenderOptions{})
+	if !ok || templateTask.GetDestPath() != "output/etc/app/app.conf" {
+		t.Errorf("included template task not modified correctly: %v", tasks)
+	}
+
+	// Files included from included files are copied too
+	if _, err := os.Stat(filepath.Join(tempDir, "tasks", "common.yml")); err != nil {
+		t.Errorf("nested task file was not copied: %v", err)
+	}
+}
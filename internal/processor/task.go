@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/zinrai/ansible-template-render/internal/ansible"
@@ -17,25 +18,35 @@ type TaskProcessor struct{}
 
 // Represents a directory creation task
 type DirectoryTask struct {
-	DestPath     string
-	PlaybookName string
+	DestPath string
+	Options  ansible.RenderOptions
+	Loop     map[string]interface{} // Iteration keywords of a task whose destination varies, nil for a fixed destination
 }
 
 // Creates a new directory task
-func NewDirectoryTask(destPath string, playbookName string) *DirectoryTask {
+func NewDirectoryTask(destPath string, opts ansible.RenderOptions) *DirectoryTask {
 	return &DirectoryTask{
-		DestPath:     destPath,
-		PlaybookName: playbookName,
+		DestPath: destPath,
+		Options:  opts,
+	}
+}
+
+// Creates a directory task repeating the loop of a task with a varying destination
+func NewLoopDirectoryTask(destPath string, task map[string]interface{}, opts ansible.RenderOptions) *DirectoryTask {
+	return &DirectoryTask{
+		DestPath: destPath,
+		Options:  opts,
+		Loop:     ansible.IterationKeywords(task),
 	}
 }
 
 // Converts the directory task to a map representation
 func (d *DirectoryTask) ToMap() map[string]interface{} {
 	// Create the full output path
-	outputPath := filepath.Join("output", d.DestPath)
+	outputPath := d.Options.OutputPath(d.DestPath)
 	dirPath := filepath.Dir(outputPath)
 
-	return map[string]interface{}{
+	task := map[string]interface{}{
 		"name": fmt.Sprintf("Ensure directory exists for %s", outputPath),
 		"file": map[string]interface{}{
 			"path":  dirPath,
@@ -43,66 +54,251 @@ func (d *DirectoryTask) ToMap() map[string]interface{} {
 			"mode":  "0755",
 		},
 		"delegate_to": "localhost",
-		"run_once":    true,
 		"tags":        []interface{}{"render_config"},
 	}
+
+	// A varying destination is only known per item, so the directory is
+	// derived from the rendered destination in the same loop
+	if d.Loop != nil {
+		for key, value := range d.Loop {
+			task[key] = value
+		}
+
+		vars := make(map[string]interface{})
+		if taskVars, ok := d.Loop["vars"].(map[string]interface{}); ok {
+			for key, value := range taskVars {
+				vars[key] = value
+			}
+		}
+		vars[loopDestVar] = outputPath
+		task["vars"] = vars
+
+		task["file"].(map[string]interface{})["path"] = fmt.Sprintf("{{ %s | dirname }}", loopDestVar)
+	}
+
+	// Per-host rendering needs a directory for every host
+	if !d.Options.PerHost {
+		task["run_once"] = true
+	}
+
+	return task
 }
 
+// Variable holding the rendered destination of a looping directory task
+const loopDestVar = "render_config_dest"
+
 // Represents the result of processing tasks
 type ProcessResult struct {
 	Tasks        []map[string]interface{}
+	Origins      []ansible.TaskOrigin // Where each task came from, parallel to Tasks
 	Modified     bool
 	HasTemplates bool
 }
 
+// Returns the edit that maps the result back onto the original task list
+func (r ProcessResult) Edit() ansible.TaskListEdit {
+	return ansible.TaskListEdit{Tasks: r.Tasks, Origins: r.Origins}
+}
+
 // Processes template tasks, inserting directory creation tasks and modifying templates
-func ProcessTemplateTasks(tasks []map[string]interface{}, taskFile string, playbookName string) ProcessResult {
+func ProcessTemplateTasks(tasks []map[string]interface{}, taskFile string, opts ansible.RenderOptions) ProcessResult {
+	processedDirs := make(map[string]bool) // Track processed directories to avoid duplicates
+	return processTaskList(tasks, processedDirs, taskFile, "", opts)
+}
+
+// Processes a task list, descending into block, rescue and always sections.
+// The list path locates the list in its file, e.g. 2/block for the block of
+// the third task, and is empty for the top-level list.
+func processTaskList(tasks []map[string]interface{}, processedDirs map[string]bool, taskFile, listPath string, opts ansible.RenderOptions) ProcessResult {
 	var result []map[string]interface{}
+	var origins []ansible.TaskOrigin
 	modified := false
 	hasTemplates := false
-	processedDirs := make(map[string]bool) // Track processed directories to avoid duplicates
 
-	for _, task := range tasks {
-		if ansible.IsTemplateTask(task) {
+	for i, task := range tasks {
+		switch {
+		case ansible.IsTemplateTask(task, opts) && !ansible.HasTemplateArgs(task, opts):
+			// Template task whose arguments cannot be read, e.g. given through args:
+			logger.Warn("Skipping template task with unsupported arguments", "file", taskFile, "task", task["name"])
+			result = append(result, task)
+			origins = append(origins, ansible.TaskOrigin{Index: i})
+		case ansible.IsTemplateTask(task, opts):
 			// Handle template task
-			taskResult, dirModified := handleTemplateTask(task, processedDirs, taskFile, playbookName)
+			taskResult, dirModified := handleTemplateTask(task, processedDirs, taskFile, opts)
+			taskResult, taskOrigins := recordRewrittenTask(task, taskResult, i, taskFile, taskPosition(listPath, i), opts)
 			result = append(result, taskResult...)
+			origins = append(origins, taskOrigins...)
 
 			modified = true
 			hasTemplates = true
 			if dirModified {
 				modified = true
 			}
-		} else {
+		case opts.BaselineDir != "" && ansible.IsEditTask(task):
+			// Handle edit task, applied to a copy seeded from the baseline
+			taskResult := handleEditTask(task, processedDirs, taskFile, opts)
+			taskResult, taskOrigins := recordRewrittenTask(task, taskResult, i, taskFile, taskPosition(listPath, i), opts)
+			result = append(result, taskResult...)
+			origins = append(origins, taskOrigins...)
+
+			modified = true
+			hasTemplates = true
+		case ansible.IsRenderedAssembleTask(task, opts):
+			// Handle assemble task joining rendered fragments
+			taskResult := handleAssembleTask(task, processedDirs, taskFile, opts)
+			taskResult, taskOrigins := recordRewrittenTask(task, taskResult, i, taskFile, taskPosition(listPath, i), opts)
+			result = append(result, taskResult...)
+			origins = append(origins, taskOrigins...)
+
+			modified = true
+			hasTemplates = true
+		case ansible.IsDynamicIncludeTask(task):
+			// Handle dynamic include, which must run for the tasks it pulls in to render
+			result = append(result, copyAndModifyIncludeTask(task))
+			origins = append(origins, ansible.TaskOrigin{Index: i})
+
+			modified = true
+		case ansible.IsBlockTask(task):
+			// Handle block task, keeping injected tasks inside the block
+			blockResult := handleBlockTask(task, i, processedDirs, taskFile, taskPosition(listPath, i), opts)
+			result = append(result, blockResult.Tasks...)
+			origins = append(origins, blockResult.Origins...)
+
+			if blockResult.Modified {
+				modified = true
+			}
+			if blockResult.HasTemplates {
+				hasTemplates = true
+			}
+		default:
 			// Non-template task, add as is
 			result = append(result, task)
+			origins = append(origins, ansible.TaskOrigin{Index: i})
 		}
 	}
 
 	return ProcessResult{
 		Tasks:        result,
+		Origins:      origins,
+		Modified:     modified,
+		HasTemplates: hasTemplates,
+	}
+}
+
+// Returns the origins of a rewritten task, preceded by the tasks injected before it
+func rewrittenTaskOrigins(taskResult []map[string]interface{}, index int) []ansible.TaskOrigin {
+	origins := make([]ansible.TaskOrigin, 0, len(taskResult))
+	for range taskResult[:len(taskResult)-1] {
+		origins = append(origins, ansible.InjectedTask())
+	}
+	return append(origins, ansible.TaskOrigin{Index: index})
+}
+
+// Returns the tasks a rewritten task became and their origins, followed by
+// the task recording the files it writes
+func recordRewrittenTask(task map[string]interface{}, taskResult []map[string]interface{}, index int, taskFile, position string, opts ansible.RenderOptions) ([]map[string]interface{}, []ansible.TaskOrigin) {
+	origins := rewrittenTaskOrigins(taskResult, index)
+
+	if recordTask := createRecordTaskIfNeeded(task, taskResult[len(taskResult)-1], taskFile, position, opts); recordTask != nil {
+		taskResult = append(taskResult, recordTask)
+		origins = append(origins, ansible.InjectedTask())
+	}
+
+	return taskResult, origins
+}
+
+// Returns the position of a task in its file from the path of its list
+func taskPosition(listPath string, index int) string {
+	if listPath == "" {
+		return strconv.Itoa(index)
+	}
+	return listPath + "/" + strconv.Itoa(index)
+}
+
+// Processes the task lists of a block task at a position in its file
+func handleBlockTask(task map[string]interface{}, index int, processedDirs map[string]bool, taskFile, position string, opts ansible.RenderOptions) ProcessResult {
+	blockCopy := make(map[string]interface{}, len(task))
+	for key, value := range task {
+		blockCopy[key] = value
+	}
+
+	sections := make(map[string]ansible.TaskListEdit)
+	modified := false
+	hasTemplates := false
+
+	for _, section := range ansible.BlockSections {
+		tasksList, ok := task[section].([]interface{})
+		if !ok {
+			continue
+		}
+
+		tasks, err := convertTasksList(tasksList)
+		if err != nil {
+			logger.Warn("Skipping block section", "file", taskFile, "section", section, "error", err)
+			continue
+		}
+
+		// Directories created before the block exist inside it, but directories
+		// created inside a section may not exist outside it
+		sectionDirs := make(map[string]bool, len(processedDirs))
+		for dir := range processedDirs {
+			sectionDirs[dir] = true
+		}
+
+		sectionResult := processTaskList(tasks, sectionDirs, taskFile, position+"/"+section, opts)
+		if !sectionResult.Modified {
+			continue
+		}
+
+		blockCopy[section] = toInterfaceList(sectionResult.Tasks)
+		sections[section] = sectionResult.Edit()
+		modified = true
+		if sectionResult.HasTemplates {
+			hasTemplates = true
+		}
+	}
+
+	if !modified {
+		return ProcessResult{
+			Tasks:   []map[string]interface{}{task},
+			Origins: []ansible.TaskOrigin{{Index: index}},
+		}
+	}
+
+	return ProcessResult{
+		Tasks:        []map[string]interface{}{blockCopy},
+		Origins:      []ansible.TaskOrigin{{Index: index, Sections: sections}},
 		Modified:     modified,
 		HasTemplates: hasTemplates,
 	}
 }
 
 // Processes a single template task
-func handleTemplateTask(task map[string]interface{}, processedDirs map[string]bool, taskFile string, playbookName string) ([]map[string]interface{}, bool) {
+func handleTemplateTask(task map[string]interface{}, processedDirs map[string]bool, taskFile string, opts ansible.RenderOptions) ([]map[string]interface{}, bool) {
 	var result []map[string]interface{}
 	dirModified := false
 
 	// Convert to TemplateTask object
-	templateTask, _ := ansible.NewTemplateTask(task)
+	templateTask, ok := ansible.NewTemplateTask(task, opts)
+	if !ok {
+		logger.Warn("Skipping template task with unsupported arguments", "file", taskFile, "task", task["name"])
+		return []map[string]interface{}{task}, false
+	}
 
 	// Add directory task if needed
-	dirTask := createDirectoryTaskIfNeeded(templateTask, processedDirs, playbookName)
+	var dirTask map[string]interface{}
+	if hasVaryingDest(task, templateTask.GetDestPath()) {
+		dirTask = NewLoopDirectoryTask(templateTask.GetDestPath(), task, opts).ToMap()
+	} else {
+		dirTask = createDirectoryTaskIfNeeded(templateTask, processedDirs, opts)
+	}
 	if dirTask != nil {
 		result = append(result, dirTask)
 		dirModified = true
 	}
 
 	// Copy and modify template task
-	modifiedTask := copyAndModifyTemplateTask(task, playbookName)
+	modifiedTask := copyAndModifyTemplateTask(task, opts)
 	result = append(result, modifiedTask)
 
 	logger.Info("Modified template task", "file", taskFile)
@@ -110,15 +306,28 @@ func handleTemplateTask(task map[string]interface{}, processedDirs map[string]bo
 	return result, dirModified
 }
 
+// Checks if the destination directory of a task can differ between runs of
+// the task: the task loops, or the directory itself is templated
+func hasVaryingDest(task map[string]interface{}, destPath string) bool {
+	if destPath == "" {
+		return false
+	}
+	return ansible.IsLoopTask(task) || strings.Contains(filepath.Dir(destPath), "{{")
+}
+
 // Creates a directory task if needed
-func createDirectoryTaskIfNeeded(templateTask *ansible.TemplateTask, processedDirs map[string]bool, playbookName string) map[string]interface{} {
-	destPath := templateTask.GetDestPath()
+func createDirectoryTaskIfNeeded(templateTask *ansible.TemplateTask, processedDirs map[string]bool, opts ansible.RenderOptions) map[string]interface{} {
+	return createDirectoryTaskForPath(templateTask.GetDestPath(), processedDirs, opts)
+}
+
+// Creates a directory task for a destination path if its directory is not yet created
+func createDirectoryTaskForPath(destPath string, processedDirs map[string]bool, opts ansible.RenderOptions) map[string]interface{} {
 	if destPath == "" {
 		return nil
 	}
 
 	// Create the full output path
-	outputPath := filepath.Join("output", destPath)
+	outputPath := opts.OutputPath(destPath)
 	dirPath := filepath.Dir(outputPath)
 
 	if processedDirs[dirPath] {
@@ -126,36 +335,38 @@ func createDirectoryTaskIfNeeded(templateTask *ansible.TemplateTask, processedDi
 	}
 
 	processedDirs[dirPath] = true
-	return NewDirectoryTask(destPath, playbookName).ToMap()
+	return NewDirectoryTask(destPath, opts).ToMap()
 }
 
 // Creates a modified copy of a template task
-func copyAndModifyTemplateTask(task map[string]interface{}, playbookName string) map[string]interface{} {
+func copyAndModifyTemplateTask(task map[string]interface{}, opts ansible.RenderOptions) map[string]interface{} {
 	taskCopy, err := utils.DeepCopy(task)
 	if err != nil {
 		logger.Warn("Error copying task", "error", err)
 		return task // Use original if copying fails
 	}
 
-	// Modify the template task with playbook name
-	ansible.ModifyTemplateTask(taskCopy.(map[string]interface{}), playbookName)
+	// Modify the template task with the render options
+	ansible.ModifyTemplateTask(taskCopy.(map[string]interface{}), opts)
 	return taskCopy.(map[string]interface{})
 }
 
-// Gets the destination path from a template task
-func getTemplateDestPath(task map[string]interface{}) string {
-	templateTask, isTemplate := ansible.NewTemplateTask(task)
-	if !isTemplate {
-		return ""
+// Creates a copy of a dynamic include tagged to run when rendering
+func copyAndModifyIncludeTask(task map[string]interface{}) map[string]interface{} {
+	taskCopy, err := utils.DeepCopy(task)
+	if err != nil {
+		logger.Warn("Error copying task", "error", err)
+		return task // Use original if copying fails
 	}
 
-	return templateTask.GetDestPath()
+	ansible.ModifyIncludeTask(taskCopy.(map[string]interface{}))
+	return taskCopy.(map[string]interface{})
 }
 
 // Processes all tasks in a role, looking for and modifying templates
-func (p *TaskProcessor) ProcessRoleTasks(roleName, tempDir string, playbookName string) (bool, error) {
+func (p *TaskProcessor) ProcessRoleTasks(roleName, tempDir string, opts ansible.RenderOptions) (bool, error) {
 	// Find task files
-	taskFiles, err := finder.FindRoleTasks(roleName)
+	taskFiles, err := opts.SearchPaths.FindRoleTasks(roleName)
 	if err != nil {
 		// If tasks directory doesn't exist, it's not an error - just no templates
 		if os.IsNotExist(err) || strings.Contains(err.Error(), "not found") {
@@ -169,11 +380,24 @@ func (p *TaskProcessor) ProcessRoleTasks(roleName, tempDir string, playbookName
 		return false, nil
 	}
 
+	rolePath, err := opts.SearchPaths.FindRolePath(roleName)
+	if err != nil {
+		return false, nil
+	}
+
 	hasTemplates := false
+	tempRolePath := filepath.Join(tempDir, opts.SearchPaths.RoleTempRelPath(roleName))
+	opts.Role = roleName
+
+	// Process each task file, following include_tasks and import_tasks
+	for _, taskFile := range ansible.FollowTaskIncludes(taskFiles, filepath.Join(rolePath, "tasks")) {
+		tempTaskFile, ok := roleTempPath(taskFile, rolePath, tempRolePath, tempDir)
+		if !ok {
+			logger.Warn("Skipping task file outside the role tree", "role", roleName, "file", taskFile)
+			continue
+		}
 
-	// Process each task file
-	for _, taskFile := range taskFiles {
-		fileHasTemplates, err := p.ProcessTaskFile(taskFile, tempDir, playbookName)
+		fileHasTemplates, err := p.processTaskFileTo(taskFile, tempTaskFile, opts)
 		if err != nil {
 			return false, err
 		}
@@ -186,16 +410,52 @@ func (p *TaskProcessor) ProcessRoleTasks(roleName, tempDir string, playbookName
 	return hasTemplates, nil
 }
 
-// Processes a single task file
-func (p *TaskProcessor) ProcessTaskFile(taskFile, tempDir string, playbookName string) (bool, error) {
-	// Create the corresponding path in the temporary directory
-	relPath, err := filepath.Rel(".", taskFile)
+// Finds the task files of a role, including the files they include or import
+func findAllRoleTaskFiles(roleName string, paths finder.SearchPaths) []string {
+	taskFiles, err := paths.FindRoleTasks(roleName)
+	if err != nil || len(taskFiles) == 0 {
+		return nil
+	}
+
+	rolePath, err := paths.FindRolePath(roleName)
 	if err != nil {
-		return false, fmt.Errorf("getting relative path: %w", err)
+		return nil
 	}
 
-	tempTaskFile := filepath.Join(tempDir, relPath)
+	return ansible.FollowTaskIncludes(taskFiles, filepath.Join(rolePath, "tasks"))
+}
+
+// Maps a file belonging to a role to its path in the temporary directory.
+// Paths are kept relative to the directory the role was found in, mirrored
+// next to the role's copy; files that would land outside the temporary
+// directory cannot be mirrored.
+func roleTempPath(path, rolePath, tempRolePath, tempDir string) (string, bool) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+
+	absRoleParent, err := filepath.Abs(filepath.Dir(rolePath))
+	if err != nil {
+		return "", false
+	}
+
+	relPath, err := filepath.Rel(absRoleParent, absPath)
+	if err != nil {
+		return "", false
+	}
+
+	tempPath := filepath.Join(filepath.Dir(tempRolePath), relPath)
+	relToTemp, err := filepath.Rel(tempDir, tempPath)
+	if err != nil || relToTemp == ".." || strings.HasPrefix(relToTemp, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return tempPath, true
+}
 
+// Processes a single task file, writing the result to tempTaskFile
+func (p *TaskProcessor) processTaskFileTo(taskFile, tempTaskFile string, opts ansible.RenderOptions) (bool, error) {
 	// Load the task file
 	tasks, err := ansible.LoadTaskFile(taskFile)
 	if err != nil {
@@ -203,7 +463,7 @@ func (p *TaskProcessor) ProcessTaskFile(taskFile, tempDir string, playbookName s
 	}
 
 	// Process the tasks
-	result := ProcessTemplateTasks(tasks, taskFile, playbookName)
+	result := ProcessTemplateTasks(tasks, taskFile, opts)
 
 	// No modifications needed, just copy the file
 	if !result.Modified {
@@ -220,7 +480,7 @@ func (p *TaskProcessor) ProcessTaskFile(taskFile, tempDir string, playbookName s
 		return false, fmt.Errorf("creating temp task directory: %w", err)
 	}
 
-	if err := ansible.SaveTaskFile(result.Tasks, tempTaskFile); err != nil {
+	if err := ansible.SaveTaskFileFrom(taskFile, result.Edit(), tempTaskFile); err != nil {
 		return false, fmt.Errorf("saving modified task file: %w", err)
 	}
 
@@ -228,17 +488,16 @@ func (p *TaskProcessor) ProcessTaskFile(taskFile, tempDir string, playbookName s
 }
 
 // Processes tasks for all roles
-func ProcessAllRoles(roles []string, tempDir string, playbookName string) (bool, error) {
+func ProcessAllRoles(roles []string, tempDir string, opts ansible.RenderOptions) (bool, error) {
 	processor := &TaskProcessor{}
 	hasTemplates := false
 
 	for _, roleName := range roles {
 		logger.Info("Processing role tasks", "name", roleName)
 
-		roleHasTemplates, err := processor.ProcessRoleTasks(roleName, tempDir, playbookName)
+		roleHasTemplates, err := processor.ProcessRoleTasks(roleName, tempDir, opts)
 		if err != nil {
-			logger.Warn("Error processing role tasks", "role", roleName, "error", err)
-			continue // Skip to next role
+			return false, fmt.Errorf("processing role %s: %w", roleName, err)
 		}
 
 		if roleHasTemplates {
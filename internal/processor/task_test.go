@@ -1,12 +1,15 @@
 package processor
 
 import (
+	"encoding/json"
+	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/zinrai/ansible-template-render/internal/ansible"
+	"github.com/zinrai/ansible-template-render/internal/manifest"
 )
 
 func TestProcessTemplateTasks(t *testing.T) {
@@ -100,7 +103,7 @@ func TestProcessTemplateTasks(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Pass a test playbook name
-			result := ProcessTemplateTasks(tt.tasks, "test_file.yml", "test-playbook")
+			result := ProcessTemplateTasks(tt.tasks, "test_file.yml", ansible.RenderOptions{PlaybookName: "test-playbook"})
 
 			if result.Modified != tt.expectedModified {
 				t.Errorf("ProcessTemplateTasks().Modified = %v, want %v", result.Modified, tt.expectedModified)
@@ -133,9 +136,9 @@ func TestProcessTemplateTasks(t *testing.T) {
 			// If tasks contain templates, verify they are modified
 			if tt.expectedHasTemplates {
 				for _, task := range result.Tasks {
-					if ansible.IsTemplateTask(task) {
+					if ansible.IsTemplateTask(task, ansible.RenderOptions{}) {
 						// Check if template dest includes the correct path
-						templateTask, _ := ansible.NewTemplateTask(task)
+						templateTask, _ := ansible.NewTemplateTask(task, ansible.RenderOptions{})
 						destPath := templateTask.GetDestPath()
 						if !strings.HasPrefix(destPath, "output/") {
 							t.Errorf("Template task not modified correctly: %v", destPath)
@@ -152,6 +155,92 @@ func TestProcessTemplateTasks(t *testing.T) {
 	}
 }
 
+func TestProcessTemplateTasks_NestedBlocks(t *testing.T) {
+	tasks := []map[string]interface{}{
+		{
+			"name": "Configure app",
+			"when": "app_enabled",
+			"block": []interface{}{
+				map[string]interface{}{
+					"name": "Inner block",
+					"block": []interface{}{
+						map[string]interface{}{
+							"template": map[string]interface{}{
+								"src":  "app.conf.j2",
+								"dest": "/etc/app/app.conf",
+							},
+						},
+					},
+				},
+			},
+			"rescue": []interface{}{
+				map[string]interface{}{
+					"template": map[string]interface{}{
+						"src":  "fallback.conf.j2",
+						"dest": "/etc/app/fallback.conf",
+					},
+				},
+			},
+			"always": []interface{}{
+				map[string]interface{}{
+					"command": "echo done",
+				},
+			},
+		},
+	}
+
+	result := ProcessTemplateTasks(tasks, "test_file.yml", ansible.RenderOptions{PlaybookName: "test-playbook"})
+
+	if !result.Modified || !result.HasTemplates {
+		t.Fatalf("ProcessTemplateTasks() Modified = %v, HasTemplates = %v, want true, true",
+			result.Modified, result.HasTemplates)
+	}
+
+	if len(result.Tasks) != 1 {
+		t.Fatalf("ProcessTemplateTasks() returned %d tasks, want 1 block", len(result.Tasks))
+	}
+
+	outer := result.Tasks[0]
+	if outer["when"] != "app_enabled" {
+		t.Errorf("block when = %v, want app_enabled", outer["when"])
+	}
+
+	// Directory task is injected inside the nested block
+	inner := outer["block"].([]interface{})[0].(map[string]interface{})
+	innerTasks := inner["block"].([]interface{})
+	if len(innerTasks) != 2 {
+		t.Fatalf("inner block has %d tasks, want 2 (directory task + template task)", len(innerTasks))
+	}
+
+	dirTask := innerTasks[0].(map[string]interface{})
+	if name, _ := dirTask["name"].(string); !strings.Contains(name, "Ensure directory exists") {
+		t.Errorf("first inner task is not a directory task: %v", dirTask)
+	}
+
+	templateTask, _ := ansible.NewTemplateTask(innerTasks[1].(map[string]interface{}), ansible.RenderOptions{})
+	if templateTask == nil || templateTask.GetDestPath() != "output/etc/app/app.conf" {
+		t.Errorf("inner template task not modified correctly: %v", innerTasks[1])
+	}
+
+	// Rescue gets its own directory task since block tasks may not have run
+	rescueTasks := outer["rescue"].([]interface{})
+	if len(rescueTasks) != 2 {
+		t.Errorf("rescue has %d tasks, want 2 (directory task + template task)", len(rescueTasks))
+	}
+
+	// Sections without templates are untouched
+	alwaysTasks := outer["always"].([]interface{})
+	if len(alwaysTasks) != 1 {
+		t.Errorf("always has %d tasks, want 1", len(alwaysTasks))
+	}
+
+	// The original block is not modified
+	originalInner := tasks[0]["block"].([]interface{})[0].(map[string]interface{})
+	if len(originalInner["block"].([]interface{})) != 1 {
+		t.Errorf("original block was modified")
+	}
+}
+
 func TestHandleTemplateTask(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -196,6 +285,19 @@ func TestHandleTemplateTask(t *testing.T) {
 			expectedTasksLen: 1, // Only template task
 			expectedDirAdded: false,
 		},
+		{
+			name: "template task with unreadable arguments",
+			task: map[string]interface{}{
+				"template": nil,
+				"args": map[string]interface{}{
+					"src":  "app.conf.j2",
+					"dest": "/etc/app/app.conf",
+				},
+			},
+			existingDirs:     map[string]bool{},
+			expectedTasksLen: 1, // Task left as it is
+			expectedDirAdded: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -206,7 +308,7 @@ func TestHandleTemplateTask(t *testing.T) {
 			}
 
 			// Pass a test playbook name
-			tasks, dirAdded := handleTemplateTask(tt.task, processedDirs, "test_file.yml", "test-playbook")
+			tasks, dirAdded := handleTemplateTask(tt.task, processedDirs, "test_file.yml", ansible.RenderOptions{PlaybookName: "test-playbook"})
 
 			if len(tasks) != tt.expectedTasksLen {
 				t.Errorf("handleTemplateTask() returned %d tasks, want %d", len(tasks), tt.expectedTasksLen)
@@ -228,7 +330,7 @@ func TestHandleTemplateTask(t *testing.T) {
 
 			// The last task should be the modified template task
 			templateTask := tasks[len(tasks)-1]
-			if !ansible.IsTemplateTask(templateTask) {
+			if !ansible.IsTemplateTask(templateTask, ansible.RenderOptions{}) {
 				t.Errorf("Last task is not a template task: %v", templateTask)
 			}
 		})
@@ -278,7 +380,7 @@ func TestCreateDirectoryTaskIfNeeded(t *testing.T) {
 			}
 
 			// Pass a test playbook name
-			dirTask := createDirectoryTaskIfNeeded(templateTask, processedDirs, "test-playbook")
+			dirTask := createDirectoryTaskIfNeeded(templateTask, processedDirs, ansible.RenderOptions{PlaybookName: "test-playbook"})
 
 			if tt.expectTask && dirTask == nil {
 				t.Errorf("createDirectoryTaskIfNeeded() returned nil, expected a task")
@@ -329,7 +431,7 @@ func TestCopyAndModifyTemplateTask(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Pass a test playbook name
-			modifiedTask := copyAndModifyTemplateTask(tt.task, "test-playbook")
+			modifiedTask := copyAndModifyTemplateTask(tt.task, ansible.RenderOptions{PlaybookName: "test-playbook"})
 
 			// Verify it's not the same object reference
 			if &modifiedTask == &tt.task {
@@ -337,7 +439,7 @@ func TestCopyAndModifyTemplateTask(t *testing.T) {
 			}
 
 			// Check if template dest includes correct path
-			templateTask, _ := ansible.NewTemplateTask(modifiedTask)
+			templateTask, _ := ansible.NewTemplateTask(modifiedTask, ansible.RenderOptions{})
 			destPath := templateTask.GetDestPath()
 			if !strings.HasPrefix(destPath, "output/") {
 				t.Errorf("Template task not modified correctly: %v", destPath)
@@ -367,6 +469,7 @@ func TestDirectoryTask_ToMap(t *testing.T) {
 		name            string
 		destPath        string
 		playbookName    string
+		perHost         bool
 		expectedPath    string
 		expectedName    string
 		expectedState   string
@@ -393,11 +496,25 @@ func TestDirectoryTask_ToMap(t *testing.T) {
 			expectedTags:    []interface{}{"render_config"},
 			expectedRunOnce: true,
 		},
+		{
+			name:            "per-host path",
+			destPath:        "/etc/app.conf",
+			playbookName:    "test-playbook",
+			perHost:         true,
+			expectedPath:    "output/{{ inventory_hostname }}/etc",
+			expectedName:    "Ensure directory exists for output/{{ inventory_hostname }}/etc/app.conf",
+			expectedState:   "directory",
+			expectedTags:    []interface{}{"render_config"},
+			expectedRunOnce: false,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			dirTask := NewDirectoryTask(tt.destPath, tt.playbookName)
+			dirTask := NewDirectoryTask(tt.destPath, ansible.RenderOptions{
+				PlaybookName: tt.playbookName,
+				PerHost:      tt.perHost,
+			})
 			result := dirTask.ToMap()
 
 			// Check task name
@@ -431,8 +548,8 @@ func TestDirectoryTask_ToMap(t *testing.T) {
 			}
 
 			// Check run_once
-			runOnce, ok := result["run_once"].(bool)
-			if !ok || runOnce != tt.expectedRunOnce {
+			runOnce, _ := result["run_once"].(bool)
+			if runOnce != tt.expectedRunOnce {
 				t.Errorf("ToMap().run_once = %v, want %v", result["run_once"], tt.expectedRunOnce)
 			}
 
@@ -445,50 +562,653 @@ func TestDirectoryTask_ToMap(t *testing.T) {
 	}
 }
 
-func TestGetTemplateDestPath(t *testing.T) {
-	tests := []struct {
-		name     string
-		task     map[string]interface{}
-		expected string
-	}{
+func TestTaskProcessor_ProcessRoleTasksFollowsIncludes(t *testing.T) {
+	tempDir := t.TempDir()
+	projectDir := filepath.Join(tempDir, "project")
+	outDir := filepath.Join(tempDir, "out")
+
+	files := map[string]string{
+		"roles/web/tasks/main.yml": `---
+- include_tasks: setup/install.yml
+- ansible.builtin.import_tasks:
+    file: ../../shared/nginx.yml
+`,
+		"roles/web/tasks/setup/install.yml": `---
+- name: Install packages
+  package:
+    name: nginx
+`,
+		"roles/shared/nginx.yml": `---
+- name: Configure nginx
+  template:
+    src: nginx.conf.j2
+    dest: /etc/nginx/nginx.conf
+`,
+	}
+
+	for path, content := range files {
+		fullPath := filepath.Join(projectDir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	defer os.Chdir(currentDir)
+	os.Chdir(projectDir)
+
+	processor := &TaskProcessor{}
+	hasTemplates, err := processor.ProcessRoleTasks("web", outDir, ansible.RenderOptions{PlaybookName: "test-playbook"})
+	if err != nil {
+		t.Fatalf("ProcessRoleTasks() error = %v", err)
+	}
+
+	if !hasTemplates {
+		t.Errorf("ProcessRoleTasks() did not find the template in the included task file")
+	}
+
+	tasks, err := ansible.LoadTaskFile(filepath.Join(outDir, "roles", "shared", "nginx.yml"))
+	if err != nil {
+		t.Fatalf("included task file was not written: %v", err)
+	}
+
+	templateTask, ok := ansible.NewTemplateTask(tasks[len(tasks)-1], ansible.RenderOptions{})
+	if !ok || templateTask.GetDestPath() != "output/etc/nginx/nginx.conf" {
+		t.Errorf("included template task not modified correctly: %v", tasks)
+	}
+}
+
+func TestProcessAllRoles_SingleTaskAndUnsupportedLayouts(t *testing.T) {
+	projectDir := t.TempDir()
+	outDir := t.TempDir()
+
+	files := map[string]string{
+		// A single task mapping is rewritten into a task list
+		"roles/single/tasks/main.yml": `name: Configure motd
+template:
+  src: motd.j2
+  dest: /etc/motd
+`,
+		// Flow-style lists cannot be edited in place
+		"roles/flow/tasks/main.yml": `[{template: {src: a.j2, dest: /etc/a.conf}}]
+`,
+	}
+	for path, content := range files {
+		fullPath := filepath.Join(projectDir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	t.Chdir(projectDir)
+
+	opts := ansible.RenderOptions{PlaybookName: "site"}
+	hasTemplates, err := ProcessAllRoles([]string{"single"}, outDir, opts)
+	if err != nil || !hasTemplates {
+		t.Fatalf("ProcessAllRoles() = %v, %v, want true, nil", hasTemplates, err)
+	}
+	tasks, err := ansible.LoadTaskFile(filepath.Join(outDir, "roles", "single", "tasks", "main.yml"))
+	if err != nil {
+		t.Fatalf("rewritten task file not readable: %v", err)
+	}
+	templateTask, ok := ansible.NewTemplateTask(tasks[len(tasks)-1], opts)
+	if !ok || templateTask.GetDestPath() != "output/etc/motd" {
+		t.Errorf("single task not rewritten: %v", tasks)
+	}
+
+	// A role that cannot be rewritten fails the render instead of losing its templates
+	if _, err := ProcessAllRoles([]string{"single", "flow"}, outDir, opts); err == nil {
+		t.Errorf("ProcessAllRoles() should fail for a role it cannot rewrite")
+	}
+}
+
+func TestTaskProcessor_ProcessTaskFilePreservesFormatting(t *testing.T) {
+	tempDir := t.TempDir()
+	taskFile := filepath.Join(tempDir, "main.yml")
+	outFile := filepath.Join(tempDir, "out", "main.yml")
+
+	original := `---
+# Configure the app
+- name: Install package   # keep me
+  apt:
+    name: app
+    state: present
+
+- name: Render config
+  template:
+    src: "app.conf.j2"   # the source
+    dest: '/etc/app/app.conf'
+    owner: root
+    mode: "0644"
+  notify:
+    - restart app
+
+- block:
+    - name: Inner
+      ansible.builtin.template:
+        src: inner.j2
+        dest: /etc/inner.conf
+  rescue:
+    - debug: msg="failed"
+`
+	if err := os.WriteFile(taskFile, []byte(original), 0644); err != nil {
+		t.Fatalf("Failed to write task file: %v", err)
+	}
+
+	processor := &TaskProcessor{}
+	if _, err := processor.processTaskFileTo(taskFile, outFile, ansible.RenderOptions{PlaybookName: "test-playbook"}); err != nil {
+		t.Fatalf("processTaskFileTo() error = %v", err)
+	}
+
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	// Only the template tasks change; comments, key order and quoting are kept
+	expected := `---
+# Configure the app
+- name: Install package   # keep me
+  apt:
+    name: app
+    state: present
+
+- name: Ensure directory exists for output/etc/app/app.conf
+  delegate_to: localhost
+  file:
+    mode: "0755"
+    path: output/etc/app
+    state: directory
+  run_once: true
+  tags:
+  - render_config
+- name: Render config
+  template:
+    src: "app.conf.j2"   # the source
+    dest: 'output/etc/app/app.conf'
+  delegate_to: localhost
+  run_once: true
+  tags:
+  - render_config
+
+- block:
+    - name: Ensure directory exists for output/etc/inner.conf
+      delegate_to: localhost
+      file:
+        mode: "0755"
+        path: output/etc
+        state: directory
+      run_once: true
+      tags:
+      - render_config
+    - name: Inner
+      ansible.builtin.template:
+        src: inner.j2
+        dest: output/etc/inner.conf
+      delegate_to: localhost
+      run_once: true
+      tags:
+      - render_config
+  rescue:
+    - debug: msg="failed"
+`
+	if string(data) != expected {
+		t.Errorf("processTaskFileTo() output =\n%s\nwant\n%s", data, expected)
+	}
+}
+
+func TestProcessTemplateTasks_EditTasks(t *testing.T) {
+	tasks := []map[string]interface{}{
 		{
-			name: "standard template",
-			task: map[string]interface{}{
-				"template": map[string]interface{}{
-					"src":  "app.conf.j2",
-					"dest": "/etc/app/app.conf",
-				},
+			"name": "Disable root login",
+			"lineinfile": map[string]interface{}{
+				"path": "/etc/ssh/sshd_config",
+				"line": "PermitRootLogin no",
 			},
-			expected: "/etc/app/app.conf",
 		},
+	}
+
+	// Without a baseline, edit tasks are left alone
+	result := ProcessTemplateTasks(tasks, "test_file.yml", ansible.RenderOptions{})
+	if result.Modified || len(result.Tasks) != 1 {
+		t.Fatalf("ProcessTemplateTasks() without baseline modified edit task: %v", result.Tasks)
+	}
+
+	opts := ansible.RenderOptions{OutputDir: "/srv/out", BaselineDir: "/srv/baseline"}
+	result = ProcessTemplateTasks(tasks, "test_file.yml", opts)
+
+	if !result.Modified || !result.HasTemplates {
+		t.Errorf("ProcessTemplateTasks() Modified = %v, HasTemplates = %v, want true", result.Modified, result.HasTemplates)
+	}
+
+	// Directory task, seed task, edit task
+	if len(result.Tasks) != 3 {
+		t.Fatalf("ProcessTemplateTasks() returned %d tasks, want 3", len(result.Tasks))
+	}
+
+	seed := result.Tasks[1]["copy"].(map[string]interface{})
+	if seed["src"] != "/srv/baseline/etc/ssh/sshd_config" || seed["dest"] != "/srv/out/etc/ssh/sshd_config" {
+		t.Errorf("seed task copies %v to %v", seed["src"], seed["dest"])
+	}
+	if seed["force"] != false {
+		t.Errorf("seed task should not overwrite earlier output")
+	}
+
+	edit := result.Tasks[2]["lineinfile"].(map[string]interface{})
+	if edit["path"] != "/srv/out/etc/ssh/sshd_config" {
+		t.Errorf("edit task path = %v", edit["path"])
+	}
+
+	expectedOrigins := []ansible.TaskOrigin{ansible.InjectedTask(), ansible.InjectedTask(), {Index: 0}}
+	if !reflect.DeepEqual(result.Origins, expectedOrigins) {
+		t.Errorf("Origins = %v, want %v", result.Origins, expectedOrigins)
+	}
+}
+
+func TestProcessTemplateTasks_LoopedEditTask(t *testing.T) {
+	tasks := []map[string]interface{}{
 		{
-			name: "builtin template",
+			"name": "Tune sites",
+			"lineinfile": map[string]interface{}{
+				"path": "/etc/nginx/sites/{{ item.name }}.conf",
+				"line": "client_max_body_size {{ item.size }};",
+			},
+			"loop":         []interface{}{map[string]interface{}{"name": "a", "size": "1m"}},
+			"loop_control": map[string]interface{}{"label": "{{ item.name }}"},
+			"when":         "nginx_enabled",
+			"vars":         map[string]interface{}{"owner": "www-data"},
+		},
+	}
+
+	opts := ansible.RenderOptions{OutputDir: "/srv/out", BaselineDir: "/srv/baseline"}
+	result := ProcessTemplateTasks(tasks, "test_file.yml", opts)
+
+	// Directory task, seed task, edit task
+	if len(result.Tasks) != 3 {
+		t.Fatalf("ProcessTemplateTasks() returned %d tasks, want 3", len(result.Tasks))
+	}
+
+	for _, task := range result.Tasks[:2] {
+		for _, key := range []string{"loop", "loop_control"} {
+			if !reflect.DeepEqual(task[key], tasks[0][key]) {
+				t.Errorf("%v: %s = %v, want %v", task["name"], key, task[key], tasks[0][key])
+			}
+		}
+	}
+
+	seed := result.Tasks[1]
+	if got := seed["copy"].(map[string]interface{})["dest"]; got != "/srv/out/etc/nginx/sites/{{ item.name }}.conf" {
+		t.Errorf("seed task dest = %v", got)
+	}
+	wantWhen := []interface{}{"nginx_enabled", "baseline_file is file"}
+	if !reflect.DeepEqual(seed["when"], wantWhen) {
+		t.Errorf("seed task when = %v, want %v", seed["when"], wantWhen)
+	}
+	wantVars := map[string]interface{}{
+		"owner":         "www-data",
+		"baseline_file": "/srv/baseline/etc/nginx/sites/{{ item.name }}.conf",
+	}
+	if !reflect.DeepEqual(seed["vars"], wantVars) {
+		t.Errorf("seed task vars = %v, want %v", seed["vars"], wantVars)
+	}
+
+	// The directory follows the rendered destination of each item
+	dirPath := result.Tasks[0]["file"].(map[string]interface{})["path"]
+	if dirPath != "{{ render_config_dest | dirname }}" {
+		t.Errorf("directory task path = %v", dirPath)
+	}
+}
+
+func TestProcessTemplateTasks_AssembleRenderedFragments(t *testing.T) {
+	tasks := []map[string]interface{}{
+		{
+			"template": map[string]interface{}{
+				"src":  "frontend.cfg.j2",
+				"dest": "/etc/haproxy/conf.d/10-frontend.cfg",
+			},
+		},
+		{
+			"assemble": map[string]interface{}{
+				"src":  "/etc/haproxy/conf.d",
+				"dest": "/etc/haproxy/haproxy.cfg",
+			},
+		},
+	}
+
+	opts := ansible.RenderOptions{RenderedDirs: map[string]bool{}}
+	for _, dir := range ansible.ExtractRenderedDirs(tasks, ansible.RenderOptions{}) {
+		opts.RenderedDirs[dir] = true
+	}
+
+	result := ProcessTemplateTasks(tasks, "test_file.yml", opts)
+
+	// Directory task and template, then directory task and assemble
+	if len(result.Tasks) != 4 {
+		t.Fatalf("ProcessTemplateTasks() returned %d tasks, want 4", len(result.Tasks))
+	}
+
+	assemble := result.Tasks[3]["assemble"].(map[string]interface{})
+	if assemble["src"] != "output/etc/haproxy/conf.d" || assemble["dest"] != "output/etc/haproxy/haproxy.cfg" {
+		t.Errorf("assemble task not modified correctly: %v", assemble)
+	}
+	if result.Tasks[3]["delegate_to"] != "localhost" {
+		t.Errorf("assemble task not delegated to localhost")
+	}
+}
+
+func TestProcessTemplateTasks_LoopWithTemplatedDest(t *testing.T) {
+	recordsDir := t.TempDir()
+	tasks := []map[string]interface{}{
+		{
+			"name": "Configure vhosts",
+			"template": map[string]interface{}{
+				"src":  "vhost.conf.j2",
+				"dest": "/etc/nginx/{{ item.site }}/{{ item.name }}.conf",
+			},
+			"loop": "{{ vhosts }}",
+			"when": "item.enabled",
+		},
+	}
+
+	opts := ansible.RenderOptions{RecordsDir: recordsDir}
+	result := ProcessTemplateTasks(tasks, "roles/nginx/tasks/main.yml", opts)
+
+	// Directory task, template task, record task
+	if len(result.Tasks) != 3 {
+		t.Fatalf("ProcessTemplateTasks() returned %d tasks, want 3", len(result.Tasks))
+	}
+
+	dirTask := result.Tasks[0]
+	if dirTask["loop"] != "{{ vhosts }}" || dirTask["when"] != "item.enabled" {
+		t.Errorf("directory task does not loop like the template task: %v", dirTask)
+	}
+	if path := dirTask["file"].(map[string]interface{})["path"]; path != "{{ render_config_dest | dirname }}" {
+		t.Errorf("directory task path = %v", path)
+	}
+	vars := dirTask["vars"].(map[string]interface{})
+	if vars["render_config_dest"] != "output/etc/nginx/{{ item.site }}/{{ item.name }}.conf" {
+		t.Errorf("directory task dest var = %v", vars["render_config_dest"])
+	}
+
+	templateTask := result.Tasks[1]
+	if templateTask["register"] != "render_config_result" {
+		t.Errorf("template task register = %v, want render_config_result", templateTask["register"])
+	}
+
+	recordTask := result.Tasks[2]
+	record := recordTask["copy"].(map[string]interface{})
+	if record["content"] != "{{ {'hosts': ansible_play_hosts, 'result': render_config_result} | to_json }}" {
+		t.Errorf("record task content = %v", record["content"])
+	}
+	if !strings.HasPrefix(record["dest"].(string), recordsDir) {
+		t.Errorf("record task dest = %v, want a path in %s", record["dest"], recordsDir)
+	}
+
+	expectedOrigins := []ansible.TaskOrigin{ansible.InjectedTask(), {Index: 0}, ansible.InjectedTask()}
+	if !reflect.DeepEqual(result.Origins, expectedOrigins) {
+		t.Errorf("Origins = %v, want %v", result.Origins, expectedOrigins)
+	}
+}
+
+func TestProcessTemplateTasks_RecordsRewrittenTasks(t *testing.T) {
+	recordsDir := t.TempDir()
+	tasks := []map[string]interface{}{
+		{
+			"name": "Configure sudoers",
+			"template": map[string]interface{}{
+				"src":      "sudoers.j2",
+				"dest":     "/etc/sudoers",
+				"owner":    "root",
+				"mode":     uint64(0440),
+				"validate": "visudo -cf %s",
+			},
+			"register": "sudoers_result",
+		},
+		{
+			"name": "Configure motd",
+			"template": map[string]interface{}{
+				"src":  "motd.j2",
+				"dest": "/etc/motd",
+			},
+		},
+	}
+
+	opts := ansible.RenderOptions{RecordsDir: recordsDir, Role: "base", PerHost: true}
+	result := ProcessTemplateTasks(tasks, "roles/base/tasks/main.yml", opts)
+
+	// Directory task for /etc, then each template task followed by its record task
+	if len(result.Tasks) != 5 {
+		t.Fatalf("ProcessTemplateTasks() returned %d tasks, want 5", len(result.Tasks))
+	}
+
+	if result.Tasks[1]["register"] != "sudoers_result" {
+		t.Errorf("template task register = %v, want sudoers_result", result.Tasks[1]["register"])
+	}
+	record := result.Tasks[2]["copy"].(map[string]interface{})
+	if record["content"] != "{{ {'hosts': [inventory_hostname], 'result': sudoers_result} | to_json }}" {
+		t.Errorf("record task content = %v", record["content"])
+	}
+
+	if result.Tasks[3]["register"] != "render_config_result" {
+		t.Errorf("template task register = %v, want render_config_result", result.Tasks[3]["register"])
+	}
+	if _, hasCopy := result.Tasks[4]["copy"]; !hasCopy {
+		t.Errorf("template task is not followed by a record task: %v", result.Tasks[4])
+	}
+
+	data, err := os.ReadFile(filepath.Join(recordsDir, manifest.TaskID("roles/base/tasks/main.yml", "0", tasks[0]), "task.json"))
+	if err != nil {
+		t.Fatalf("task record not written: %v", err)
+	}
+
+	var taskRecord manifest.TaskRecord
+	if err := json.Unmarshal(data, &taskRecord); err != nil {
+		t.Fatalf("task record is not valid JSON: %v", err)
+	}
+
+	expected := manifest.TaskRecord{
+		ID:       manifest.TaskID("roles/base/tasks/main.yml", "0", tasks[0]),
+		Role:     "base",
+		TaskFile: "roles/base/tasks/main.yml",
+		TaskName: "Configure sudoers",
+		Src:      "sudoers.j2",
+		Dest:     "/etc/sudoers",
+		Path:     "output/{{ inventory_hostname }}/etc/sudoers",
+		Owner:    "root",
+		Mode:     "0440",
+		Validate: "visudo -cf %s",
+	}
+	if !reflect.DeepEqual(taskRecord, expected) {
+		t.Errorf("task record = %+v, want %+v", taskRecord, expected)
+	}
+}
+
+func TestNewTaskRecord_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		task map[string]interface{}
+	}{
+		{
+			name: "edit task",
 			task: map[string]interface{}{
-				"ansible.builtin.template": map[string]interface{}{
-					"src":  "app.conf.j2",
-					"dest": "/etc/app/app.conf",
+				"lineinfile": map[string]interface{}{
+					"path":     "/etc/sudoers",
+					"line":     "%admin ALL=(ALL) ALL",
+					"validate": "visudo -cf %s",
 				},
 			},
-			expected: "/etc/app/app.conf",
 		},
 		{
-			name: "not a template",
+			name: "assemble task",
 			task: map[string]interface{}{
-				"copy": map[string]interface{}{
-					"src":  "file",
-					"dest": "/etc/file",
+				"assemble": map[string]interface{}{
+					"src":      "/etc/sudoers.d",
+					"dest":     "/etc/sudoers",
+					"validate": "visudo -cf %s",
 				},
 			},
-			expected: "",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := getTemplateDestPath(tt.task)
-			if result != tt.expected {
-				t.Errorf("getTemplateDestPath() = %v, want %v", result, tt.expected)
+			record, ok := newTaskRecord(tt.task, "tasks/main.yml", "0", ansible.RenderOptions{})
+			if !ok {
+				t.Fatalf("newTaskRecord() did not record the task")
+			}
+			if record.Validate != "visudo -cf %s" {
+				t.Errorf("record.Validate = %q, want %q", record.Validate, "visudo -cf %s")
 			}
 		})
 	}
 }
+
+func TestProcessTemplateTasks_IdenticalTasksGetOwnRecords(t *testing.T) {
+	recordsDir := t.TempDir()
+	motd := func() map[string]interface{} {
+		return map[string]interface{}{
+			"template": map[string]interface{}{"src": "motd.j2", "dest": "/etc/motd"},
+		}
+	}
+	tasks := []map[string]interface{}{
+		motd(),
+		motd(),
+		{"block": []interface{}{motd()}, "rescue": []interface{}{motd()}},
+	}
+
+	opts := ansible.RenderOptions{RecordsDir: recordsDir}
+	ProcessTemplateTasks(tasks, "tasks/main.yml", opts)
+
+	entries, err := os.ReadDir(recordsDir)
+	if err != nil {
+		t.Fatalf("Failed to read records directory: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("identical tasks wrote %d records, want 4", len(entries))
+	}
+
+	for _, position := range []string{"0", "1", "2/block/0", "2/rescue/0"} {
+		id := manifest.TaskID("tasks/main.yml", position, motd())
+		if _, err := os.Stat(filepath.Join(recordsDir, id, "task.json")); err != nil {
+			t.Errorf("no record for the task at %s: %v", position, err)
+		}
+	}
+}
+
+func TestProcessTemplateTasks_TagsDynamicIncludes(t *testing.T) {
+	tasks := []map[string]interface{}{
+		{"include_tasks": "config.yml"},
+		{
+			"name":    "Include legacy",
+			"include": "legacy.yml",
+			"tags":    []interface{}{"setup"},
+		},
+		{"import_tasks": "static.yml"},
+		{
+			"ansible.builtin.include_role": map[string]interface{}{
+				"name":       "nginx",
+				"tasks_from": "vhost",
+			},
+		},
+		{"import_role": map[string]interface{}{"name": "certs"}},
+	}
+
+	result := ProcessTemplateTasks(tasks, "main.yml", ansible.RenderOptions{PlaybookName: "test-playbook"})
+
+	if !result.Modified || result.HasTemplates {
+		t.Fatalf("ProcessTemplateTasks() Modified = %v, HasTemplates = %v, want true, false",
+			result.Modified, result.HasTemplates)
+	}
+	if len(result.Tasks) != len(tasks) {
+		t.Fatalf("ProcessTemplateTasks() returned %d tasks, want %d", len(result.Tasks), len(tasks))
+	}
+
+	// Static imports pass their tags on to the imported tasks, so they are left alone
+	expectedTags := [][]interface{}{
+		{"render_config"},
+		{"setup", "render_config"},
+		nil,
+		{"render_config"},
+		nil,
+	}
+	for i, expected := range expectedTags {
+		tags, _ := result.Tasks[i]["tags"].([]interface{})
+		if !reflect.DeepEqual(tags, expected) {
+			t.Errorf("task %d tags = %v, want %v", i, tags, expected)
+		}
+	}
+
+	// The include keeps its parameters and is edited in place
+	if result.Tasks[0]["include_tasks"] != "config.yml" || result.Origins[0].Index != 0 {
+		t.Errorf("include task not kept in place: %v, origin %v", result.Tasks[0], result.Origins[0])
+	}
+	if _, tagged := tasks[0]["tags"]; tagged {
+		t.Errorf("original include task was modified: %v", tasks[0])
+	}
+}
+
+func TestProcessTemplateTasks_FreeFormArgs(t *testing.T) {
+	tasks := []map[string]interface{}{
+		{
+			"block": []interface{}{
+				map[string]interface{}{
+					"name":     "Free-form template",
+					"template": "src=a.j2 dest=/etc/a.conf mode=0644",
+				},
+				map[string]interface{}{
+					"name":     "Free-form looping template",
+					"template": "src={{ item }}.j2 dest='/etc/{{ item }}/app.conf'",
+					"loop":     []interface{}{"one", "two"},
+				},
+				map[string]interface{}{
+					"name":     "Arguments given separately",
+					"template": nil,
+					"args":     map[string]interface{}{"src": "b.j2", "dest": "/etc/b.conf"},
+				},
+			},
+		},
+	}
+
+	result := ProcessTemplateTasks(tasks, "main.yml", ansible.RenderOptions{PlaybookName: "test-playbook"})
+
+	if !result.Modified || !result.HasTemplates {
+		t.Fatalf("ProcessTemplateTasks() Modified = %v, HasTemplates = %v, want true, true",
+			result.Modified, result.HasTemplates)
+	}
+
+	block, err := convertTasksList(result.Tasks[0]["block"].([]interface{}))
+	if err != nil {
+		t.Fatalf("block is not a task list: %v", err)
+	}
+
+	var destPaths []string
+	for _, task := range block {
+		if templateTask, ok := ansible.NewTemplateTask(task, ansible.RenderOptions{}); ok {
+			destPaths = append(destPaths, templateTask.GetDestPath())
+		}
+	}
+
+	expected := []string{"output/etc/a.conf", "output/etc/{{ item }}/app.conf"}
+	if !reflect.DeepEqual(destPaths, expected) {
+		t.Errorf("template destinations = %v, want %v", destPaths, expected)
+	}
+
+	// The free-form string is replaced by parameters, without file attributes
+	module, ok := block[1]["template"].(map[string]interface{})
+	if !ok || module["src"] != "a.j2" || module["mode"] != nil {
+		t.Errorf("free-form template not rewritten correctly: %v", block[1]["template"])
+	}
+
+	// The unsupported task is kept as is
+	last := block[len(block)-1]
+	if last["name"] != "Arguments given separately" || last["tags"] != nil {
+		t.Errorf("unsupported template task was modified: %v", last)
+	}
+}
@@ -0,0 +1,99 @@
+package scenario
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Outcomes of running a scenario
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"  // Rendered output differs from the expected output
+	StatusError   = "error"   // Rendering failed
+	StatusUpdated = "updated" // Expected output was rewritten
+)
+
+// Describes the outcome of running a scenario
+type Result struct {
+	Name     string
+	Status   string
+	Message  string
+	Details  string // Diff of a failed scenario, or the error of a broken one
+	Duration time.Duration
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Writes the results as a JUnit XML report with a single test suite
+func WriteJUnit(path, suiteName string, results []Result) error {
+	suite := junitTestSuite{
+		Name:  suiteName,
+		Tests: len(results),
+	}
+
+	var total time.Duration
+	for _, result := range results {
+		testCase := junitTestCase{
+			Name:      result.Name,
+			ClassName: suiteName,
+			Time:      formatSeconds(result.Duration),
+		}
+
+		switch result.Status {
+		case StatusFailed:
+			suite.Failures++
+			testCase.Failure = &junitProblem{Message: result.Message, Text: result.Details}
+		case StatusError:
+			suite.Errors++
+			testCase.Error = &junitProblem{Message: result.Message, Text: result.Details}
+		}
+
+		suite.Cases = append(suite.Cases, testCase)
+		total += result.Duration
+	}
+	suite.Time = formatSeconds(total)
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling JUnit report: %w", err)
+	}
+
+	content := append([]byte(xml.Header), data...)
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("writing JUnit report: %w", err)
+	}
+
+	return nil
+}
+
+// Formats a duration in seconds, as JUnit reports expect
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
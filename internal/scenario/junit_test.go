@@ -0,0 +1,50 @@
+package scenario
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.xml")
+	results := []Result{
+		{Name: "web", Status: StatusPassed, Duration: 1500 * time.Millisecond},
+		{Name: "db", Status: StatusFailed, Message: "rendered files differ", Details: "-a\n+b\n", Duration: time.Second},
+		{Name: "cache", Status: StatusError, Message: "rendering failed", Details: "boom"},
+	}
+
+	if err := WriteJUnit(path, "suite", results); err != nil {
+		t.Fatalf("WriteJUnit() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read report: %v", err)
+	}
+
+	var report junitTestSuites
+	if err := xml.Unmarshal(data, &report); err != nil {
+		t.Fatalf("report is not valid XML: %v", err)
+	}
+
+	if len(report.Suites) != 1 {
+		t.Fatalf("report has %d suites, want 1", len(report.Suites))
+	}
+	suite := report.Suites[0]
+	if suite.Name != "suite" || suite.Tests != 3 || suite.Failures != 1 || suite.Errors != 1 || suite.Time != "2.500" {
+		t.Errorf("suite = %+v", suite)
+	}
+
+	if suite.Cases[0].Failure != nil || suite.Cases[0].Error != nil {
+		t.Errorf("passed case has a problem: %+v", suite.Cases[0])
+	}
+	if failure := suite.Cases[1].Failure; failure == nil || failure.Message != "rendered files differ" || failure.Text != "-a\n+b\n" {
+		t.Errorf("failed case = %+v", suite.Cases[1])
+	}
+	if problem := suite.Cases[2].Error; problem == nil || problem.Text != "boom" {
+		t.Errorf("broken case = %+v", suite.Cases[2])
+	}
+}
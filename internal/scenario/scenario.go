@@ -0,0 +1,143 @@
+package scenario
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Name of the file describing a scenario
+const FileName = "scenario.yml"
+
+// Name of the directory holding a scenario's expected output
+const ExpectedDirName = "expected"
+
+// Playbook rendered when a scenario names none
+const DefaultPlaybook = "site.yml"
+
+// Inventory used when neither the scenario nor the command line names one
+const DefaultInventory = "inventory"
+
+// Describes a playbook rendered with an inventory and the output expected from it
+type Scenario struct {
+	Name      string   `yaml:"-"`
+	Dir       string   `yaml:"-"`
+	Playbook  string   `yaml:"playbook"`  // Relative to the scenario directory
+	Inventory []string `yaml:"inventory"` // Inventory sources, relative to the scenario directory
+	Args      []string `yaml:"args"`      // Additional arguments for ansible-playbook
+	PerHost   bool     `yaml:"per_host"`
+	Limit     string   `yaml:"limit"` // Ansible host pattern selecting the hosts to render for
+}
+
+// Returns the absolute path of the scenario's playbook
+func (s *Scenario) PlaybookPath() string {
+	playbook := s.Playbook
+	if playbook == "" {
+		playbook = DefaultPlaybook
+	}
+	return s.resolve(playbook)
+}
+
+// Returns the absolute paths of the scenario's inventory sources, or fallback
+// when it names none; without a fallback, the inventory in the scenario
+// directory
+func (s *Scenario) InventoryPaths(fallback []string) []string {
+	switch {
+	case len(s.Inventory) > 0:
+		paths := make([]string, 0, len(s.Inventory))
+		for _, path := range s.Inventory {
+			paths = append(paths, s.resolve(path))
+		}
+		return paths
+	case len(fallback) > 0:
+		paths := make([]string, 0, len(fallback))
+		for _, path := range fallback {
+			if absPath, err := filepath.Abs(path); err == nil {
+				path = absPath
+			}
+			paths = append(paths, path)
+		}
+		return paths
+	default:
+		return []string{s.resolve(DefaultInventory)}
+	}
+}
+
+// Returns the directory holding the scenario's expected output
+func (s *Scenario) ExpectedDir() string {
+	return filepath.Join(s.Dir, ExpectedDirName)
+}
+
+// Resolves a path relative to the scenario directory
+func (s *Scenario) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(s.Dir, path)
+}
+
+// Loads the scenario described by the scenario file in dir
+func Load(dir string) (*Scenario, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving scenario directory: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(absDir, FileName))
+	if err != nil {
+		return nil, fmt.Errorf("reading scenario: %w", err)
+	}
+
+	scenario := &Scenario{}
+	if err := yaml.Unmarshal(data, scenario); err != nil {
+		return nil, fmt.Errorf("parsing scenario %s: %w", dir, err)
+	}
+
+	scenario.Name = filepath.Base(absDir)
+	scenario.Dir = absDir
+	return scenario, nil
+}
+
+// Finds the scenarios in a directory: the directory itself when it has a
+// scenario file, otherwise each subdirectory that has one, sorted by name
+func Discover(root string) ([]*Scenario, error) {
+	if _, err := os.Stat(filepath.Join(root, FileName)); err == nil {
+		scenario, err := Load(root)
+		if err != nil {
+			return nil, err
+		}
+		return []*Scenario{scenario}, nil
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("reading scenarios directory: %w", err)
+	}
+
+	var scenarios []*Scenario
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(root, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, FileName)); err != nil {
+			continue
+		}
+
+		scenario, err := Load(dir)
+		if err != nil {
+			return nil, err
+		}
+		scenarios = append(scenarios, scenario)
+	}
+
+	sort.Slice(scenarios, func(i, j int) bool {
+		return scenarios[i].Name < scenarios[j].Name
+	})
+
+	return scenarios, nil
+}
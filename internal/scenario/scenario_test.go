@@ -0,0 +1,79 @@
+package scenario
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Creates a scenario directory with the given scenario file content
+func writeScenario(t *testing.T, root, name, content string) string {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create scenario directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write scenario: %v", err)
+	}
+	return dir
+}
+
+func TestDiscover(t *testing.T) {
+	root := t.TempDir()
+	webDir := writeScenario(t, root, "web", "playbook: ../site.yml\ninventory: [hosts.ini, inventories/prod]\nargs: [-e, env=prod]\nper_host: true\nlimit: web:!canary\n")
+	dbDir := writeScenario(t, root, "db", "")
+	if err := os.MkdirAll(filepath.Join(root, "not-a-scenario"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	scenarios, err := Discover(root)
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if len(scenarios) != 2 {
+		t.Fatalf("Discover() returned %d scenarios, want 2", len(scenarios))
+	}
+
+	db, web := scenarios[0], scenarios[1]
+	if db.Name != "db" || web.Name != "web" {
+		t.Fatalf("Discover() names = %s, %s, want db, web", db.Name, web.Name)
+	}
+
+	if got := web.PlaybookPath(); got != filepath.Join(root, "site.yml") {
+		t.Errorf("PlaybookPath() = %s", got)
+	}
+	want := []string{filepath.Join(webDir, "hosts.ini"), filepath.Join(webDir, "inventories/prod")}
+	if got := web.InventoryPaths([]string{"/srv/inventory"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("InventoryPaths() = %v, want the scenario's inventory", got)
+	}
+	if len(web.Args) != 2 || !web.PerHost || web.Limit != "web:!canary" {
+		t.Errorf("scenario settings not loaded: %+v", web)
+	}
+
+	if got := db.PlaybookPath(); got != filepath.Join(dbDir, DefaultPlaybook) {
+		t.Errorf("PlaybookPath() = %s, want the default playbook", got)
+	}
+	if got := db.InventoryPaths([]string{"/srv/inventory"}); !reflect.DeepEqual(got, []string{"/srv/inventory"}) {
+		t.Errorf("InventoryPaths() = %v, want the fallback", got)
+	}
+	if got := db.InventoryPaths(nil); !reflect.DeepEqual(got, []string{filepath.Join(dbDir, DefaultInventory)}) {
+		t.Errorf("InventoryPaths() = %v, want the default inventory", got)
+	}
+	if got := db.ExpectedDir(); got != filepath.Join(dbDir, ExpectedDirName) {
+		t.Errorf("ExpectedDir() = %s", got)
+	}
+}
+
+func TestDiscover_SingleScenario(t *testing.T) {
+	dir := writeScenario(t, t.TempDir(), "web", "")
+
+	scenarios, err := Discover(dir)
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if len(scenarios) != 1 || scenarios[0].Dir != dir {
+		t.Errorf("Discover() = %+v, want the directory itself", scenarios)
+	}
+}
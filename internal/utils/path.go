@@ -3,6 +3,7 @@ package utils
 import (
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // Helps manage paths consistently
@@ -39,3 +40,33 @@ func (p *PathResolver) EnsureDirectoryExists(path string) error {
 	absPath := p.ResolvePath(path)
 	return os.MkdirAll(absPath, 0755)
 }
+
+// Returns the deepest directory containing all the given paths
+func CommonDir(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+
+	common := filepath.Clean(paths[0])
+	for _, path := range paths[1:] {
+		path = filepath.Clean(path)
+		for !IsWithinDir(path, common) {
+			parent := filepath.Dir(common)
+			if parent == common {
+				break
+			}
+			common = parent
+		}
+	}
+
+	return common
+}
+
+// Checks whether path is dir itself or located below it
+func IsWithinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}